@@ -0,0 +1,13 @@
+module.exports = {
+  async up(knex) {
+    await knex.schema.alterTable('durations', (table) => {
+      table.string('client_class', 16);
+    });
+  },
+
+  async down(knex) {
+    await knex.schema.alterTable('durations', (table) => {
+      table.dropColumn('client_class');
+    });
+  },
+};
@@ -0,0 +1,13 @@
+module.exports = {
+  async up(knex) {
+    await knex.schema.alterTable('durations', (table) => {
+      table.integer('randomized');
+    });
+  },
+
+  async down(knex) {
+    await knex.schema.alterTable('durations', (table) => {
+      table.dropColumn('randomized');
+    });
+  },
+};
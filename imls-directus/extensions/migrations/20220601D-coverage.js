@@ -0,0 +1,22 @@
+module.exports = {
+  async up(knex) {
+    await knex.schema.createTable('coverage', (table) => {
+      table.increments('id');
+      table.string('pi_serial', 16);
+      table.string('fcfs_seq_id', 16);
+      table.string('device_tag', 32);
+      table.string('session_id', 255);
+      table.integer('scans');
+      table.integer('failed_scans');
+      table.integer('capture_seconds');
+      table.integer('frames');
+      table.integer('adapters');
+      table.bigInteger('first_scan');
+      table.bigInteger('last_scan');
+    });
+  },
+
+  async down(knex) {
+    await knex.schema.dropTable('coverage');
+  },
+};
@@ -0,0 +1,24 @@
+module.exports = {
+  async up(knex) {
+    await knex.schema.createTable('aggregates', (table) => {
+      table.increments('id');
+      table.string('pi_serial', 16);
+      table.string('fcfs_seq_id', 16);
+      table.string('device_tag', 32);
+      table.string('session_id', 255);
+      table.string('period', 16);
+      table.bigInteger('start');
+      table.integer('seconds');
+      table.integer('count');
+      table.string('mechanism', 16);
+      table.float('epsilon');
+      table.float('delta');
+      table.float('sensitivity');
+      table.float('scale');
+    });
+  },
+
+  async down(knex) {
+    await knex.schema.dropTable('aggregates');
+  },
+};
@@ -0,0 +1,17 @@
+module.exports = {
+  async up(knex) {
+    await knex.schema.alterTable('coverage', (table) => {
+      table.integer('k_threshold');
+      table.integer('suppressed_durations');
+      table.integer('merged_buckets');
+    });
+  },
+
+  async down(knex) {
+    await knex.schema.alterTable('coverage', (table) => {
+      table.dropColumn('k_threshold');
+      table.dropColumn('suppressed_durations');
+      table.dropColumn('merged_buckets');
+    });
+  },
+};
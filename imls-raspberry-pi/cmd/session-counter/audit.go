@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gsa.gov/18f/internal/audit"
+	"gsa.gov/18f/internal/state"
+)
+
+var privacyAuditCmd = &cobra.Command{
+	Use:   "privacy-audit [PATH...]",
+	Short: "Look for identifiers in the data kept on the device",
+	Long: `privacy-audit looks through the durations and queues databases, the
+web root and the images, the logs, the at-rest working directory, and
+any other files or directories given, for anything that looks like a MAC address, an email address,
+or the device serial outside the column that is meant to hold it.
+Databases encrypted at rest are decrypted with storage.secret to be
+looked through. It reports what it finds, redacted, and exits non-zero if
+it found anything or could not look through everything.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		state.SetConfigAtPath(cfgFile)
+		serial := state.GetSerial()
+		if serial == state.FakeSerial {
+			serial = ""
+		}
+		a := audit.NewAuditor(serial, state.SealedExt, func(in string, out string) error {
+			return state.DecryptFile(in, out, state.GetStorageSecretPath())
+		})
+
+		// Where the counter keeps things, if it has kept them yet. The
+		// ephemeral store should only ever hold hashed MACs; the working
+		// directory holds the decrypted copies of the databases.
+		for _, path := range []string{
+			state.GetDurationsPath(),
+			state.GetDurationsPath() + state.SealedExt,
+			state.GetQueuesPath(),
+			state.GetQueuesPath() + state.SealedExt,
+			state.GetEphemeralPath(),
+			state.GetWWWRoot(),
+			state.GetWWWImages(),
+			state.GetLogPath(),
+			state.GetStorageWorkdir(),
+		} {
+			if err := a.Audit(path); err != nil && !os.IsNotExist(err) {
+				log.Fatal().
+					Err(err).
+					Str("path", path).
+					Msg("could not audit")
+			}
+		}
+		for _, path := range args {
+			if err := a.Audit(path); err != nil {
+				log.Fatal().
+					Err(err).
+					Str("path", path).
+					Msg("could not audit")
+			}
+		}
+
+		report := a.Report()
+		report.Write(os.Stdout)
+		if !report.Clean() {
+			os.Exit(1)
+		}
+	},
+}
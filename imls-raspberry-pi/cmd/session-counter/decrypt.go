@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gsa.gov/18f/internal/state"
+)
+
+var (
+	decryptOut    string
+	decryptSecret string
+)
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt FILE" + state.SealedExt,
+	Short: "Decrypt a database that was encrypted at rest",
+	Long: `decrypt writes a plain SQLite copy of a database sealed with
+storage.encrypt, for export and support. It needs the device secret the
+database was sealed with; by default, the one in storage.secret.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		state.SetConfigAtPath(cfgFile)
+		in := args[0]
+		out := decryptOut
+		if out == "" {
+			out = strings.TrimSuffix(filepath.Base(in), state.SealedExt)
+			if out == filepath.Base(in) {
+				out += ".sqlite"
+			}
+		}
+		secret := decryptSecret
+		if secret == "" {
+			secret = state.GetStorageSecretPath()
+		}
+		if err := state.DecryptFile(in, out, secret); err != nil {
+			log.Fatal().
+				Err(err).
+				Str("file", in).
+				Msg("could not decrypt")
+		}
+		fmt.Printf("wrote %s\n", out)
+	},
+}
+
+func init() {
+	decryptCmd.Flags().StringVar(&decryptOut,
+		"out",
+		"",
+		"where to write the decrypted database (default: the file name without "+state.SealedExt+")")
+	decryptCmd.Flags().StringVar(&decryptSecret,
+		"secret",
+		"",
+		"device secret to decrypt with (default: storage.secret)")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gsa.gov/18f/cmd/session-counter/tlp"
+	"gsa.gov/18f/internal/state"
+)
+
+var (
+	learnPcap     string
+	learnFraction float64
+	learnAdd      bool
+)
+
+func addExclusions(entries []string) {
+	err := state.AddExclusions(entries)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("could not add exclusions")
+	}
+	fmt.Printf("excluding %d devices and prefixes\n", len(state.GetExclusions()))
+}
+
+var excludeCmd = &cobra.Command{
+	Use:   "exclude",
+	Short: "Manage the list of devices that are never counted",
+	Long: `exclude manages the list of library devices (access points, printers,
+staff laptops) that should not be counted as patrons. Only keyed hashes of
+the addresses are written to the config file.`,
+}
+
+var excludeAddCmd = &cobra.Command{
+	Use:   "add MAC|BSSID|OUI...",
+	Short: "Exclude MAC addresses, BSSIDs, or three-octet OUI prefixes",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		state.SetConfigAtPath(cfgFile)
+		addExclusions(args)
+	},
+}
+
+var excludeLearnCmd = &cobra.Command{
+	Use:   "learn",
+	Short: "Propose exclusions from a capture of a full opening day",
+	Long: `learn reads captures covering an opening day and lists the devices
+that were present for nearly all of it. Review the list, then exclude them
+with --add or with exclude add.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		state.SetConfigAtPath(cfgFile)
+		files, err := tlp.CaptureFiles(learnPcap)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Str("pcap", learnPcap).
+				Msg("could not find captures to learn from")
+		}
+		candidates, err := tlp.LearnExclusions(files, learnFraction)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("could not learn exclusions")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MAC\tMANUFACTURER\tFIRST SEEN\tLAST SEEN")
+		macs := make([]string, 0)
+		for _, c := range candidates {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.MAC, c.Manufacturer,
+				c.First.In(time.Local).Format("15:04"), c.Last.In(time.Local).Format("15:04"))
+			macs = append(macs, c.MAC)
+		}
+		w.Flush()
+
+		if learnAdd && len(macs) > 0 {
+			addExclusions(macs)
+		}
+	},
+}
+
+func init() {
+	excludeLearnCmd.Flags().StringVar(&learnPcap,
+		"pcap",
+		"",
+		"capture file, or directory of capture files, covering an opening day")
+	excludeLearnCmd.Flags().Float64Var(&learnFraction,
+		"fraction",
+		0.9,
+		"how much of the day a device must be present for to be proposed")
+	excludeLearnCmd.Flags().BoolVar(&learnAdd,
+		"add",
+		false,
+		"exclude every proposed device")
+	excludeLearnCmd.MarkFlagRequired("pcap")
+	excludeCmd.AddCommand(excludeAddCmd)
+	excludeCmd.AddCommand(excludeLearnCmd)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gsa.gov/18f/cmd/session-counter/tlp"
+	"gsa.gov/18f/internal/state"
+)
+
+var (
+	replayPcap  string
+	replayStart string
+	replayOut   string
+)
+
+// Accepted formats for --start, most specific first.
+var replayStartFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+func parseReplayStart(s string) (time.Time, error) {
+	var err error
+	for _, format := range replayStartFormats {
+		var t time.Time
+		t, err = time.ParseInLocation(format, s, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func launchReplay() {
+	state.SetConfigAtPath(cfgFile)
+
+	var start time.Time
+	if replayStart != "" {
+		var err error
+		start, err = parseReplayStart(replayStart)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Str("start", replayStart).
+				Msg("could not parse start time")
+		}
+	}
+
+	files, err := tlp.CaptureFiles(replayPcap)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Str("pcap", replayPcap).
+			Msg("could not find captures to replay")
+	}
+
+	// Never touch the live databases or the API from a replay.
+	os.MkdirAll(filepath.Join(replayOut, "images"), 0755)
+	state.SetStorageMode("local")
+	state.SetRootPath(replayOut)
+	state.SetImagesPath(filepath.Join(replayOut, "images"))
+	state.SetDurationsPath(filepath.Join(replayOut, "durations.sqlite"))
+	state.SetQueuesPath(filepath.Join(replayOut, "queues.sqlite"))
+
+	sq := state.NewQueue("sent")
+	iq := state.NewQueue("images")
+	durationsdb := state.GetDurationsDatabase()
+
+	err = tlp.Replay(files, start, func() {
+		processAndReset(durationsdb, sq, iq)
+	})
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("replay failed")
+	}
+	log.Info().
+		Str("output", replayOut).
+		Msg("replay complete")
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Run the session pipeline over recorded captures",
+	Long: `replay feeds pcap/pcapng captures through the same pipeline the
+monitor uses, on a simulated clock driven by the packet timestamps. Results
+are written under --out; nothing is sent to the API.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		launchReplay()
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayPcap,
+		"pcap",
+		"",
+		"capture file, or directory of capture files, to replay")
+	replayCmd.Flags().StringVar(&replayStart,
+		"start",
+		"",
+		"local time to shift the first packet to (default: use packet timestamps)")
+	replayCmd.Flags().StringVar(&replayOut,
+		"out",
+		"replay",
+		"directory for the durations, queues, and images written by the replay")
+	replayCmd.MarkFlagRequired("pcap")
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"gsa.gov/18f/cmd/session-counter/tlp"
+	"gsa.gov/18f/internal/interfaces"
 	"gsa.gov/18f/internal/state"
 	"gsa.gov/18f/internal/version"
 	"gsa.gov/18f/internal/wifi-hardware-search/search"
@@ -32,36 +36,90 @@ func runEvery(crontab string, c *cron.Cron, fun func()) {
 	}
 }
 
+// startSession moves the current session's devices to the durations
+// table and starts the next session. Any capture is let finish storing
+// what it saw, and the rest held until the new session has started, so
+// every sighting lands in exactly one session.
+func startSession(durationsdb interfaces.Database, sq *state.Queue, iq *state.Queue) {
+	state.PauseCapture()
+	// Capture resumes even if processing panics.
+	defer state.ResumeCapture()
+	// Copy ephemeral durations over to the durations table
+	tlp.ProcessData(durationsdb, sq, iq)
+	// Increment the session counter
+	state.IncrementSessionID()
+	// Clear out the ephemeral data for the next day of monitoring
+	state.ClearEphemeralDB()
+}
+
+// processAndReset closes out the current session. It runs on the reset
+// cron, and at day boundaries when replaying captures.
+func processAndReset(durationsdb interfaces.Database, sq *state.Queue, iq *state.Queue) {
+	log.Info().
+		Str("time", fmt.Sprintf("%v", state.GetClock().Now().In(time.Local))).
+		Msg("RUNNING PROCESSDATA")
+	startSession(durationsdb, sq, iq)
+	// Draw images of the data
+	tlp.WriteImages(durationsdb)
+	// Try sending the data
+	tlp.SimpleSend(durationsdb)
+	// Write the day back to disk, encrypted, if `storage.encrypt` is on.
+	state.SealDatabases()
+}
+
 func run2() {
 	sq := state.NewQueue("sent")
 	iq := state.NewQueue("images")
 	durationsdb := state.GetDurationsDatabase()
-	c := cron.New()
+	// Pick the day back up, if we went down partway through it.
+	state.OpenEphemeralStore()
+	// Seal straight away, so plaintext databases left from before
+	// `storage.encrypt` was turned on do not sit in the web root all day.
+	state.SealDatabases()
+	// A job still running when it comes due again (a burst capture that
+	// overran its minute, say) is skipped rather than run twice at once.
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
 
-	go runEvery("*/1 * * * *", c,
-		func() {
-			log.Debug().Msg("RUNNING SIMPLESHARK")
-			tlp.SimpleShark(
-				search.SetMonitorMode,
-				search.SearchForMatchingDevice,
-				tlp.TSharkRunner)
-		})
+	// Hop channels underneath whichever capture mode is running. This
+	// returns immediately if `channels.hop` is off.
+	go tlp.HopChannels(search.SearchForMatchingDevices, search.SetChannel, nil)
+
+	if state.GetCaptureMode() == "stream" {
+		// A nil stop channel: stream until the process exits.
+		go tlp.MultiStreamShark(
+			search.SetMonitorMode,
+			search.SearchForMatchingDevices,
+			tlp.GetStreamFn(),
+			nil)
+	} else {
+		sharkFn := tlp.GetSharkFn()
+		// Each adapter that fails is retried on its own backoff rather
+		// than every minute; the ephemeral data stays put either way.
+		go runEvery("*/1 * * * *", c,
+			func() {
+				log.Debug().Msg("RUNNING SIMPLESHARK")
+				tlp.MultiShark(
+					search.SetMonitorMode,
+					search.SearchForMatchingDevices,
+					sharkFn)
+			})
+	}
 
 	go runEvery(state.GetResetCron(), c,
 		func() {
-			log.Info().
-				Str("time", fmt.Sprintf("%v", state.GetClock().Now().In(time.Local))).
-				Msg("RUNNING PROCESSDATA")
-			// Copy ephemeral durations over to the durations table
-			tlp.ProcessData(durationsdb, sq, iq)
-			// Draw images of the data
-			tlp.WriteImages(durationsdb)
-			// Try sending the data
-			tlp.SimpleSend(durationsdb)
-			// Increment the session counter
-			state.IncrementSessionID()
-			// Clear out the ephemeral data for the next day of monitoring
-			state.ClearEphemeralDB()
+			processAndReset(durationsdb, sq, iq)
+		})
+	// Keep the sealed copies of the databases no more than one
+	// `cron.seal` behind.
+	if state.IsEncryptingAtRest() {
+		go runEvery(state.GetSealCron(), c, state.SealDatabases)
+	}
+	// Say how capture is going, whether or not anything is failing.
+	go runEvery("0 * * * *", c, tlp.LogCaptureHealth)
+	// Purge what has been uploaded and kept long enough.
+	go runEvery(state.GetPurgeCron(), c,
+		func() {
+			tlp.Purge(durationsdb)
 		})
 
 	// Start the cron jobs...
@@ -83,6 +141,19 @@ func launchTLP() {
 		Int64("session_id", state.GetCurrentSessionID()).
 		Msg("session id at launch")
 
+	// The working copies of encrypted databases do not survive a
+	// restart, so seal them on the way out.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		log.Info().
+			Str("signal", sig.String()).
+			Msg("shutting down")
+		state.SealDatabases()
+		os.Exit(0)
+	}()
+
 	// Run the network
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -118,5 +189,9 @@ func main() {
 		"session-counter.ini",
 		"config file (default is session-counter.ini in /etc/imls, %PROGRAMDATA%\\IMLS, or current directory")
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(excludeCmd)
+	rootCmd.AddCommand(decryptCmd)
+	rootCmd.AddCommand(privacyAuditCmd)
 	rootCmd.Execute()
 }
@@ -35,14 +35,14 @@ func generateFakeMac() string {
 	return string(b)
 }
 
-func runFakeWireshark(device string) []string {
+func runFakeWireshark(device string) ([]string, error) {
 
 	thisTime := rand.Intn(NUMFOUNDPERMINUTE)
 	send := make([]string, thisTime)
 	for i := 0; i < thisTime; i++ {
 		send[i] = consistentMACs[rand.Intn(len(consistentMACs))]
 	}
-	return send
+	return send, nil
 }
 
 func isItMidnight(now time.Time) bool {
@@ -73,7 +73,7 @@ func MockRun(rundays int, nummacs int, numfoundperminute int) {
 		for minutes := 0; minutes < 60*24; minutes++ {
 			tlp.SimpleShark(
 				// search.SetMonitorMode,
-				func(d *models.Device) {},
+				func(d *models.Device) error { return nil },
 				// search.SearchForMatchingDevice,
 				func() *models.Device { return &models.Device{Exists: true, Logicalname: "fakewan0"} },
 				// tlp.TSharkRunner
@@ -107,7 +107,7 @@ func TestAllUp(t *testing.T) {
 	_, filename, _, _ := runtime.Caller(0)
 	fmt.Println(filename)
 	path := filepath.Dir(filename)
-	state.SetConfigAtPath(filepath.Join(path, "test", "config.sqlite"))
+	state.SetConfigAtPath(filepath.Join(t.TempDir(), "session-counter.ini"))
 	state.SetStorageMode("local")
 	state.SetRootPath(filepath.Join(path, "test", "www"))
 	state.SetImagesPath(filepath.Join(path, "test", "www", "images"))
@@ -0,0 +1,64 @@
+package tlp
+
+import (
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+)
+
+// atBoundary sorts the session's devices at a reset, under
+// `reset.boundary`, into those to report now and those to carry over to
+// the next session. A visit is still going on if the device was seen
+// within the memory window; any other visit is over, and reported as
+// it is.
+func atBoundary(macs state.EphemeralDB, now int64) ([]state.StartEnd, state.EphemeralDB) {
+	boundary := state.GetResetBoundary()
+	switch boundary {
+	case "close", "split", "carry":
+	default:
+		log.Warn().
+			Str("boundary", boundary).
+			Msg("unknown reset.boundary; closing visits at the reset")
+		boundary = "close"
+	}
+	// Sessions are named for when they started; a visit that began before
+	// this one has already been carried over once.
+	began := state.GetCurrentSessionID()
+
+	devices := make([]state.StartEnd, 0)
+	carry := make(state.EphemeralDB)
+	for key, se := range macs {
+		if se.Carried {
+			// Carried over the last reset and not seen since. Split
+			// visits have already been reported up to the reset; carried
+			// ones have not been reported at all.
+			if !se.Truncated {
+				devices = append(devices, se)
+			}
+			continue
+		}
+		if now-se.End > state.MAC_MEMORY_DURATION_SEC {
+			devices = append(devices, se)
+			continue
+		}
+
+		// Carried visits are only carried once; a device that stays
+		// through two resets is split at the second.
+		if boundary == "carry" && !se.Truncated && se.Start > began {
+			carry[key] = se
+			continue
+		}
+		se.Truncated = true
+		devices = append(devices, se)
+		if boundary != "close" {
+			carry[key] = state.StartEnd{Start: now, End: now, Class: se.Class, Randomized: se.Randomized,
+				Manufacturer: se.Manufacturer, Library: se.Library, Visit: se.Visit, Truncated: true}
+		}
+	}
+	if len(carry) > 0 {
+		log.Info().
+			Str("boundary", boundary).
+			Int("carried", len(carry)).
+			Msg("carrying visits over the reset")
+	}
+	return devices, carry
+}
@@ -0,0 +1,142 @@
+package tlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// resetAt does what processAndReset does to the session's devices, and
+// returns the ones that would be written out.
+func resetAt(now int64) []state.StartEnd {
+	devices, carry := atBoundary(state.GetMACs(), now)
+	state.CarryOver(carry)
+	state.IncrementSessionID()
+	state.ClearEphemeralDB()
+	return devices
+}
+
+func see(mac string) {
+	state.RecordSighting(state.HashSighting(structs.Sighting{MAC: mac, Class: structs.FrameData}))
+}
+
+func boundarySetup(t *testing.T, boundary string) *clock.Mock {
+	setup(t)
+	state.SetResetBoundary(boundary)
+	t.Cleanup(func() {
+		state.SetResetBoundary("close")
+		state.ClearEphemeralDB()
+	})
+	state.IncrementSessionID()
+	mock := state.GetClock().(*clock.Mock)
+	// One device left three hours before the reset; the other is still
+	// here.
+	see("00:11:22:33:44:55")
+	mock.Add(3 * time.Hour)
+	see("66:77:88:99:aa:bb")
+	return mock
+}
+
+func truncated(devices []state.StartEnd) int {
+	n := 0
+	for _, se := range devices {
+		if se.Truncated {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestBoundaryClose(t *testing.T) {
+	mock := boundarySetup(t, "close")
+	devices := resetAt(mock.Now().Unix())
+	if len(devices) != 2 || truncated(devices) != 1 {
+		t.Error("expected both visits, one of them truncated; got ", devices)
+	}
+	if len(state.GetMACs()) != 0 {
+		t.Error("nothing should be carried when closing visits")
+	}
+}
+
+func TestBoundarySplit(t *testing.T) {
+	mock := boundarySetup(t, "split")
+	reset := mock.Now().Unix()
+	devices := resetAt(reset)
+	if len(devices) != 2 || truncated(devices) != 1 {
+		t.Error("expected both visits, one of them truncated; got ", devices)
+	}
+
+	// The device still here is picked up again under the new key, from
+	// the reset.
+	mock.Add(10 * time.Minute)
+	see("66:77:88:99:aa:bb")
+	macs := state.GetMACs()
+	if len(macs) != 1 {
+		t.Fatal("expected the carried visit to move to the new key, found ", macs)
+	}
+	for key, se := range macs {
+		if key != state.HashMAC("66:77:88:99:aa:bb") || se.Start != reset || !se.Truncated || se.Carried {
+			t.Error("unexpected carried visit ", se)
+		}
+	}
+	devices = resetAt(mock.Now().Add(4 * time.Hour).Unix())
+	if len(devices) != 1 || devices[0].Start != reset || !devices[0].Truncated {
+		t.Error("expected the rest of the split visit, got ", devices)
+	}
+}
+
+func TestBoundarySplitNotSeenAgain(t *testing.T) {
+	mock := boundarySetup(t, "split")
+	resetAt(mock.Now().Unix())
+	// The device left at the reset; it was reported in full already.
+	mock.Add(24 * time.Hour)
+	if devices := resetAt(mock.Now().Unix()); len(devices) != 0 {
+		t.Error("a split visit that did not go on should not be reported twice, got ", devices)
+	}
+}
+
+func TestBoundaryCarry(t *testing.T) {
+	mock := boundarySetup(t, "carry")
+	arrived := mock.Now().Unix()
+	devices := resetAt(arrived)
+	if len(devices) != 1 || truncated(devices) != 0 {
+		t.Error("expected only the visit that was over, got ", devices)
+	}
+
+	mock.Add(10 * time.Minute)
+	see("66:77:88:99:aa:bb")
+	macs := state.GetMACs()
+	se, ok := macs[state.HashMAC("66:77:88:99:aa:bb")]
+	if len(macs) != 1 || !ok || se.Start != arrived || se.Truncated || se.Carried {
+		t.Error("expected the whole visit to be carried, got ", macs)
+	}
+
+	// Still here at the next reset: carried visits are only carried once.
+	mock.Add(time.Hour)
+	see("66:77:88:99:aa:bb")
+	devices = resetAt(mock.Now().Unix())
+	if len(devices) != 1 || devices[0].Start != arrived || !devices[0].Truncated {
+		t.Error("expected the carried visit to be split at the second reset, got ", devices)
+	}
+}
+
+func TestBoundaryCarryNotSeenAgain(t *testing.T) {
+	mock := boundarySetup(t, "carry")
+	resetAt(mock.Now().Unix())
+	mock.Add(24 * time.Hour)
+	devices := resetAt(mock.Now().Unix())
+	if len(devices) != 1 || devices[0].Truncated {
+		t.Error("a carried visit should be reported whole at the next reset, got ", devices)
+	}
+}
+
+func TestBoundaryUnknown(t *testing.T) {
+	mock := boundarySetup(t, "sideways")
+	devices := resetAt(mock.Now().Unix())
+	if len(devices) != 2 || len(state.GetMACs()) != 0 {
+		t.Error("an unknown boundary should close visits, got ", devices)
+	}
+}
@@ -0,0 +1,66 @@
+package tlp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
+)
+
+type ChannelFn func(*models.Device, int) error
+
+// How often the hopper looks for adapters again. Discovery shells out to
+// lshw for every search, which is far too slow to do every dwell.
+const ChannelRefreshInterval = 5 * time.Minute
+
+// adapterMu keeps the hopper from retuning an adapter while a capture is
+// taking it down and back up into monitor mode.
+var adapterMu sync.Mutex
+
+// setMonitor runs setMonitorFn with the hopper held off.
+func setMonitor(setMonitorFn MonitorFn, dev *models.Device) error {
+	adapterMu.Lock()
+	defer adapterMu.Unlock()
+	return setMonitorFn(dev)
+}
+
+// HopChannels walks the adapters around `channels.plan`, staying on each
+// channel for `channels.dwell`, until `stop` is closed. It runs alongside
+// the capture, which keeps listening on whatever channel it is tuned to.
+// With more than one adapter, each starts at a different point in the
+// plan, so they are never all on the same channel. If hopping is off, it
+// returns straight away. The adapters are looked for again every
+// ChannelRefreshInterval.
+func HopChannels(devicesFn DevicesFn, setChannelFn ChannelFn, stop <-chan struct{}) {
+	plan := state.GetChannelPlan()
+	if len(plan) == 0 {
+		return
+	}
+	log.Info().
+		Ints("plan", plan).
+		Str("dwell", state.GetChannelDwell().String()).
+		Msg("hopping channels")
+
+	var devices []*models.Device
+	var found time.Time
+	for ndx := 0; ; ndx = (ndx + 1) % len(plan) {
+		if now := state.GetClock().Now(); found.IsZero() || !now.Before(found.Add(ChannelRefreshInterval)) {
+			devices = existingDevices(devicesFn())
+			found = now
+		}
+		adapterMu.Lock()
+		for i, dev := range devices {
+			// Errors are logged by setChannelFn; a channel the adapter
+			// will not tune to just costs us one dwell.
+			setChannelFn(dev, plan[(ndx+i)%len(plan)])
+		}
+		adapterMu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-state.GetClock().After(state.GetChannelDwell()):
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package tlp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
+)
+
+func TestHopChannels(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+	state.SetChannelHopping(true)
+	state.SetChannelPlan("1,6,36")
+	state.SetChannelBands("2.4")
+	defer state.SetChannelHopping(false)
+
+	var mu sync.Mutex
+	tuned := make([]int, 0)
+	fakeChannelFn := func(d *models.Device, channel int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		tuned = append(tuned, channel)
+		return nil
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(tuned)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		HopChannels(singleDevice(fakeSearchFn), fakeChannelFn, stop)
+		close(done)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		eventually(t, "a channel change", func() bool { return count() == i })
+		mock.Add(state.GetChannelDwell())
+	}
+	eventually(t, "a channel change", func() bool { return count() == 4 })
+	close(stop)
+	<-done
+
+	// 36 is outside the 2.4GHz band, so the plan is just 1 and 6.
+	for i, want := range []int{1, 6, 1, 6} {
+		if tuned[i] != want {
+			t.Error("hop ", i, ": expected channel ", want, " got ", tuned[i])
+		}
+	}
+}
+
+func TestHopChannelsRefreshesAdapters(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+	state.SetChannelHopping(true)
+	state.SetChannelPlan("1,6")
+	state.SetChannelBands("2.4")
+	state.SetChannelDwell(60)
+	defer state.SetChannelHopping(false)
+	defer state.SetChannelDwell(5)
+
+	var mu sync.Mutex
+	searches, hops := 0, 0
+	devicesFn := func() []*models.Device {
+		mu.Lock()
+		defer mu.Unlock()
+		searches += 1
+		return fakeDevicesFn()
+	}
+	counts := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return searches, hops
+	}
+	channelFn := func(d *models.Device, channel int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		hops += 1
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		HopChannels(devicesFn, channelFn, stop)
+		close(done)
+	}()
+
+	// Two adapters, so two hops a dwell; five dwells to the refresh.
+	for i := 1; i <= 5; i++ {
+		eventually(t, "a channel change", func() bool { _, h := counts(); return h == 2*i })
+		if s, _ := counts(); s != 1 {
+			t.Error("looked for adapters again after ", i, " dwells")
+		}
+		mock.Add(state.GetChannelDwell())
+	}
+	eventually(t, "a channel change", func() bool { _, h := counts(); return h == 12 })
+	close(stop)
+	<-done
+	if s, _ := counts(); s != 2 {
+		t.Error("expected to look for adapters again after ", ChannelRefreshInterval, ", searched ", s, " times")
+	}
+}
+
+func TestHopChannelsStaggered(t *testing.T) {
+	setup(t)
+	state.SetChannelHopping(true)
+	state.SetChannelPlan("1,6,11")
+	state.SetChannelBands("2.4")
+	defer state.SetChannelHopping(false)
+
+	tuned := make(map[string]int)
+	stop := make(chan struct{})
+	close(stop)
+	HopChannels(fakeDevicesFn, func(d *models.Device, channel int) error {
+		tuned[d.Logicalname] = channel
+		return nil
+	}, stop)
+	if tuned["fakewan0"] != 1 || tuned["fakewan1"] != 6 || len(tuned) != 2 {
+		t.Error("expected the adapters to start on different channels ", tuned)
+	}
+}
+
+func TestHopChannelsOff(t *testing.T) {
+	setup(t)
+	state.SetChannelHopping(false)
+	HopChannels(singleDevice(fakeSearchFn), func(*models.Device, int) error {
+		t.Error("should not change channel when hopping is off")
+		return nil
+	}, nil)
+}
+
+func TestStoreMacsCountsChannels(t *testing.T) {
+	setup(t)
+	StoreMacs([]structs.Sighting{
+		{MAC: "DE:AD:BE:EF:00:00", Channel: 6},
+		{MAC: "DE:AD:BE:EF:00:00", Channel: 6},
+		{MAC: "BE:EF:00:00:00:00", Channel: 6},
+		{MAC: "BE:EF:00:00:00:00", Channel: 36},
+		{MAC: "C0:FF:EE:00:00:00"},
+	})
+	stats := state.GetChannelStats()
+	if len(stats) != 2 {
+		t.Fatal("expected 2 channels, found ", len(stats))
+	}
+	if stats[6] != (state.ChannelStat{Sightings: 3, Devices: 2}) {
+		t.Error("channel 6: ", stats[6])
+	}
+	if stats[36] != (state.ChannelStat{Sightings: 1, Devices: 1}) {
+		t.Error("channel 36: ", stats[36])
+	}
+	if len(state.GetMACs()) != 3 {
+		t.Error("expected 3 devices, found ", len(state.GetMACs()))
+	}
+}
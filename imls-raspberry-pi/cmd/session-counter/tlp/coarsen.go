@@ -0,0 +1,33 @@
+package tlp
+
+import (
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+)
+
+// granularity is the resolution, in seconds, durations are reported at.
+// Exact timestamps have a resolution of a second.
+func granularity() int64 {
+	minutes := state.GetPrivacyGranularity()
+	if minutes < 0 {
+		log.Warn().
+			Int("granularity", minutes).
+			Msg("privacy.granularity cannot be negative; reporting exact times")
+		minutes = 0
+	}
+	if minutes == 0 {
+		return 1
+	}
+	return int64(minutes) * 60
+}
+
+// coarsen widens [start, end] out to the reporting granularity: the
+// start is rounded down and the end up, so a coarsened duration still
+// covers the whole visit.
+func coarsen(start int64, end int64, g int64) (int64, int64) {
+	start -= start % g
+	if r := end % g; r != 0 {
+		end += g - r
+	}
+	return start, end
+}
@@ -0,0 +1,40 @@
+package tlp
+
+import (
+	"testing"
+
+	"gsa.gov/18f/internal/state"
+)
+
+func TestCoarsen(t *testing.T) {
+	for _, c := range []struct {
+		start, end, g      int64
+		wantStart, wantEnd int64
+	}{
+		{1000, 2000, 1, 1000, 2000},
+		{1000, 2000, 300, 900, 2100},
+		{900, 2100, 300, 900, 2100},
+		{1000, 1000, 900, 900, 1800},
+	} {
+		start, end := coarsen(c.start, c.end, c.g)
+		if start != c.wantStart || end != c.wantEnd {
+			t.Error("coarsen(", c.start, c.end, c.g, ") = ", start, end)
+		}
+	}
+}
+
+func TestGranularity(t *testing.T) {
+	setup(t)
+	defer state.SetPrivacyGranularity(0)
+	if granularity() != 1 {
+		t.Error("times should be exact by default")
+	}
+	state.SetPrivacyGranularity(15)
+	if granularity() != 900 {
+		t.Error("expected 15 minutes, got ", granularity())
+	}
+	state.SetPrivacyGranularity(-5)
+	if granularity() != 1 {
+		t.Error("a negative granularity should fall back to exact times")
+	}
+}
@@ -0,0 +1,61 @@
+package tlp
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// An ExclusionCandidate is a device that was around for nearly all of a
+// capture, which patrons rarely are.
+type ExclusionCandidate struct {
+	MAC          string
+	Manufacturer string
+	First        time.Time
+	Last         time.Time
+}
+
+// LearnExclusions reads captures of an opening day and proposes the
+// devices present for at least `fraction` of it. Randomized MACs are
+// left out; they will have changed by tomorrow anyway. So are devices
+// that are already excluded.
+func LearnExclusions(files []string, fraction float64) ([]ExclusionCandidate, error) {
+	sightings, err := readSightings(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(sightings) == 0 {
+		return nil, errors.New("no frames with source addresses in the captures")
+	}
+	span := sightings[len(sightings)-1].when.Sub(sightings[0].when)
+
+	seen := make(map[string]*ExclusionCandidate)
+	for _, s := range sightings {
+		mac := ParseSighting(s.line).MAC
+		if !isMAC(mac) || structs.IsRandomized(mac) || state.IsExcluded(mac) {
+			continue
+		}
+		c, ok := seen[mac]
+		if !ok {
+			c = &ExclusionCandidate{MAC: mac, First: s.when}
+			seen[mac] = c
+		}
+		c.Last = s.when
+	}
+
+	manufacturers := manufacturerTable()
+	candidates := make([]ExclusionCandidate, 0)
+	for mac, c := range seen {
+		if float64(c.Last.Sub(c.First)) >= fraction*float64(span) {
+			c.Manufacturer, _ = manufacturers.Manufacturer(mac)
+			candidates = append(candidates, *c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].MAC < candidates[j].MAC
+	})
+	return candidates, nil
+}
@@ -0,0 +1,47 @@
+package tlp
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"gsa.gov/18f/internal/state"
+)
+
+func TestStoreMacsExclusions(t *testing.T) {
+	setup(t)
+	defer viper.Set("exclude.list", "")
+	if err := state.AddExclusions([]string{"b8:27:eb"}); err != nil {
+		t.Fatal(err)
+	}
+	storeFixture(t)
+
+	macs := state.GetMACs()
+	if _, ok := macs[state.HashMAC("b8:27:eb:00:00:04")]; ok || len(macs) != 5 {
+		t.Error("the excluded Pi should not be counted, found ", len(macs), " devices")
+	}
+}
+
+func TestLearnExclusions(t *testing.T) {
+	setup(t)
+	defer viper.Set("exclude.list", "")
+	files := []string{captureFixture("capture.pcap")}
+
+	// Only the phone sticks around for any length of the capture.
+	candidates, err := LearnExclusions(files, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].MAC != "f0:18:98:00:00:01" {
+		t.Fatal("expected the phone as the only candidate, found ", candidates)
+	}
+	if candidates[0].Manufacturer == "" {
+		t.Error("candidates should name their manufacturer")
+	}
+
+	if err := state.AddExclusions([]string{candidates[0].MAC}); err != nil {
+		t.Fatal(err)
+	}
+	if candidates, _ = LearnExclusions(files, 0.3); len(candidates) != 0 {
+		t.Error("devices already excluded should not be proposed again")
+	}
+}
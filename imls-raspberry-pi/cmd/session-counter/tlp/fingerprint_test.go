@@ -0,0 +1,81 @@
+package tlp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func fixtureSightings(t *testing.T) []structs.Sighting {
+	src, err := capture.OpenFile(captureFixture("capture.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	sightings := make([]structs.Sighting, 0)
+	for _, line := range FrameLines(src) {
+		sightings = append(sightings, ParseSighting(line))
+	}
+	return sightings
+}
+
+func storeFixture(t *testing.T) {
+	StoreMacs(fixtureSightings(t))
+}
+
+func TestFingerprintGroupsRotatingMACs(t *testing.T) {
+	setup(t)
+	state.SetFingerprinting(true)
+	defer state.SetFingerprinting(false)
+	// The second randomized MAC turns up a minute after the first; the
+	// phone has rotated its address.
+	sightings := fixtureSightings(t)
+	StoreMacs(sightings[:2])
+	state.GetClock().(*clock.Mock).Add(time.Minute)
+	StoreMacs(sightings[2:])
+
+	macs := state.GetMACs()
+	if len(macs) != 5 {
+		t.Fatal("expected the two randomized MACs to be grouped, found ", len(macs), " devices")
+	}
+	groups := 0
+	for key, se := range macs {
+		if strings.HasPrefix(key, "fp:") {
+			groups += 1
+			if !se.Randomized {
+				t.Error("a fingerprint group should be marked randomized")
+			}
+		}
+	}
+	if groups != 1 {
+		t.Error("expected one fingerprint group, found ", groups)
+	}
+	if _, ok := macs[state.HashMAC("f0:18:98:00:00:01")]; !ok {
+		t.Error("global MACs should not be grouped")
+	}
+}
+
+func TestFingerprintSameTime(t *testing.T) {
+	setup(t)
+	state.SetFingerprinting(true)
+	defer state.SetFingerprinting(false)
+	// Seen at once, the two randomized MACs are two phones.
+	storeFixture(t)
+	if n := len(state.GetMACs()); n != 6 {
+		t.Error("expected MACs seen together to be counted apart, found ", n)
+	}
+}
+
+func TestFingerprintOff(t *testing.T) {
+	setup(t)
+	state.SetFingerprinting(false)
+	storeFixture(t)
+	if n := len(state.GetMACs()); n != 6 {
+		t.Error("expected every address to be counted, found ", n)
+	}
+}
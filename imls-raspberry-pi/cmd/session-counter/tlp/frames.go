@@ -0,0 +1,96 @@
+package tlp
+
+import (
+	"strings"
+
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// Management frame subtypes we classify on.
+const (
+	subtypeAssocRequest   = 0
+	subtypeReassocRequest = 2
+	subtypeProbeRequest   = 4
+	subtypeProbeResponse  = 5
+	subtypeBeacon         = 8
+	subtypeAuth           = 11
+)
+
+// frameClass sorts a frame into one of the structs.Frame* classes, from
+// `wlan.fc.type_subtype` (type in the high nibble, subtype in the low)
+// and the `wlan.fc.ds` bits.
+func frameClass(typeSubtype int, ds int) string {
+	frameType, subtype := (typeSubtype>>4)&0x03, typeSubtype&0x0f
+	switch {
+	case frameType == capture.TypeManagement && subtype == subtypeProbeRequest:
+		return structs.FrameProbe
+	case frameType == capture.TypeManagement &&
+		(subtype == subtypeAssocRequest || subtype == subtypeReassocRequest || subtype == subtypeAuth):
+		return structs.FrameAssoc
+	// To the DS, and not from it: a client talking to the network.
+	case frameType == capture.TypeData && ds == 0x01:
+		return structs.FrameData
+	}
+	return structs.FrameOther
+}
+
+// advertisesNetwork says whether a frame is a beacon or probe response,
+// the frames an access point names its network in.
+func advertisesNetwork(typeSubtype int) bool {
+	frameType, subtype := (typeSubtype>>4)&0x03, typeSubtype&0x0f
+	return frameType == capture.TypeManagement &&
+		(subtype == subtypeBeacon || subtype == subtypeProbeResponse)
+}
+
+// wantFrame says whether frames of a class count as sightings under
+// `capture.frames`. Frames we could not classify always count.
+func wantFrame(class string) bool {
+	classes := state.GetFrameClasses()
+	if classes == nil || class == "" {
+		return true
+	}
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Capture filters for each frame class, in pcap-filter(7) syntax.
+var classFilters = map[string]string{
+	structs.FrameProbe: "type mgt subtype probe-req",
+	structs.FrameAssoc: "type mgt subtype assoc-req or type mgt subtype reassoc-req or type mgt subtype auth",
+	structs.FrameData:  "type data and dir tods",
+}
+
+// captureFilter builds a tshark capture filter for `capture.frames`, so
+// the frames we do not want are dropped before tshark ever parses them.
+// It returns "" when there is nothing to filter; "other" is everything
+// left over, and cannot be expressed as a filter.
+func captureFilter() string {
+	classes := state.GetFrameClasses()
+	filters := make([]string, 0)
+	for _, c := range classes {
+		f, ok := classFilters[c]
+		if !ok {
+			return ""
+		}
+		filters = append(filters, "("+f+")")
+	}
+	// We learn the library's BSSIDs from its beacons, so keep those.
+	if len(filters) > 0 && len(state.GetLibrarySSIDs()) > 0 {
+		filters = append(filters, "(type mgt subtype beacon or type mgt subtype probe-resp)")
+	}
+	return strings.Join(filters, " or ")
+}
+
+// captureFilterArgs is captureFilter as tshark arguments.
+func captureFilterArgs() []string {
+	if f := captureFilter(); f != "" {
+		return []string{"-f", f}
+	}
+	return []string{}
+}
@@ -0,0 +1,96 @@
+package tlp
+
+import (
+	"testing"
+
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestFrameClass(t *testing.T) {
+	for _, c := range []struct {
+		typeSubtype int
+		ds          int
+		class       string
+	}{
+		{0x04, 0x00, structs.FrameProbe},
+		{0x00, 0x00, structs.FrameAssoc},
+		{0x02, 0x00, structs.FrameAssoc},
+		{0x0b, 0x00, structs.FrameAssoc},
+		{0x08, 0x00, structs.FrameOther}, // beacon
+		{0x20, 0x01, structs.FrameData},
+		{0x28, 0x01, structs.FrameData}, // QoS data
+		{0x28, 0x02, structs.FrameOther},
+		{0x1d, 0x00, structs.FrameOther}, // ack
+	} {
+		if got := frameClass(c.typeSubtype, c.ds); got != c.class {
+			t.Errorf("0x%04x/0x%02x: expected %s, got %s", c.typeSubtype, c.ds, c.class, got)
+		}
+	}
+	if s := ParseSighting("de:ad:be:ef:00:00\t2437\t-50\t0x0028\t0x01"); s.Class != structs.FrameData {
+		t.Error("hex fields should parse, got ", s)
+	}
+	if s := ParseSighting("de:ad:be:ef:00:00\t2437\t-50\t4\t0"); s.Class != structs.FrameProbe {
+		t.Error("decimal fields should parse, got ", s)
+	}
+}
+
+func TestCaptureFilter(t *testing.T) {
+	setup(t)
+	defer state.SetFrameClasses("all")
+	if captureFilter() != "" {
+		t.Error("there should be no filter for all frames")
+	}
+	state.SetFrameClasses("probe,data")
+	if f := captureFilter(); f != "(type mgt subtype probe-req) or (type data and dir tods)" {
+		t.Error("unexpected filter ", f)
+	}
+	state.SetFrameClasses("probe,other")
+	if captureFilter() != "" {
+		t.Error("there should be no filter when other frames are wanted")
+	}
+	state.SetLibrarySSIDs("Library")
+	defer state.SetLibrarySSIDs("")
+	state.SetFrameClasses("data")
+	if f := captureFilter(); f != "(type data and dir tods) or (type mgt subtype beacon or type mgt subtype probe-resp)" {
+		t.Error("beacons should be kept to learn the library's BSSIDs, got ", f)
+	}
+}
+
+func TestFrameLinesFiltered(t *testing.T) {
+	setup(t)
+	defer state.SetFrameClasses("all")
+	state.SetFrameClasses("probe")
+	src, err := capture.OpenFile(captureFixture("capture.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if lines := FrameLines(src); len(lines) != 3 {
+		t.Error("expected the 3 probe requests, got ", lines)
+	}
+}
+
+func TestStoreMacsFrameClasses(t *testing.T) {
+	setup(t)
+	defer state.SetFrameClasses("all")
+	state.SetFrameClasses("probe,assoc,data")
+	StoreMacs([]structs.Sighting{
+		ParseSighting("00:11:22:33:44:55\t2437\t-40\t0x0008\t0x00"),
+		ParseSighting("DE:AD:BE:EF:00:00\t2437\t-50\t0x0004\t0x00"),
+		ParseSighting("DE:AD:BE:EF:00:00\t2437\t-50\t0x0020\t0x01"),
+		ParseSighting("DE:AD:BE:EF:00:00\t2437\t-50\t0x0004\t0x00"),
+		ParseSighting("BE:EF:00:00:00:00\t2437\t-50\t0x0004\t0x00"),
+	})
+	macs := state.GetMACs()
+	if _, ok := macs[state.HashMAC("00:11:22:33:44:55")]; ok {
+		t.Error("the access point's beacon should not be counted")
+	}
+	if c := structs.ClientClass(macs[state.HashMAC("DE:AD:BE:EF:00:00")].Class); c != structs.ClientAssociated {
+		t.Error("a device sending data should be associated, got ", c)
+	}
+	if c := structs.ClientClass(macs[state.HashMAC("BE:EF:00:00:00:00")].Class); c != structs.ClientProbe {
+		t.Error("a device only probing should be probe-only, got ", c)
+	}
+}
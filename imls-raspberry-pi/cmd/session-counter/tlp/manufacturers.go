@@ -0,0 +1,52 @@
+package tlp
+
+import (
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/oui"
+	"gsa.gov/18f/internal/state"
+)
+
+var (
+	ouiOnce  sync.Once
+	ouiTable *oui.Table
+)
+
+// manufacturerTable loads the OUI table from `oui.path` the first time
+// it is needed, falling back to the embedded one.
+func manufacturerTable() *oui.Table {
+	ouiOnce.Do(func() {
+		ouiTable = loadManufacturerTable(state.GetOUIPath())
+	})
+	return ouiTable
+}
+
+func loadManufacturerTable(path string) *oui.Table {
+	if path == "" {
+		return oui.Embedded()
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("path", path).
+			Msg("could not open oui table; using the embedded one")
+		return oui.Embedded()
+	}
+	defer fh.Close()
+	t, err := oui.Load(fh)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("path", path).
+			Msg("could not read oui table; using the embedded one")
+		return oui.Embedded()
+	}
+	log.Info().
+		Int("prefixes", t.Len()).
+		Str("path", path).
+		Msg("loaded oui table")
+	return t
+}
@@ -0,0 +1,160 @@
+package tlp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+)
+
+// NativeRunner is a SharkFn that reads frames straight off the adapter
+// with the capture package, rather than shelling out to tshark. It
+// listens for the same `wireshark.duration` as TSharkRunner.
+func NativeRunner(adapter string) ([]string, error) {
+	src, err := capture.OpenLive(adapter,
+		time.Duration(state.GetWiresharkDuration())*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("could not open adapter for native capture: %w", err)
+	}
+	defer src.Close()
+	return FrameLines(src), nil
+}
+
+// frameLine formats a decoded frame the way tshark prints SharkFields.
+func frameLine(f capture.Frame) string {
+	ds := 0
+	if f.ToDS {
+		ds |= 0x01
+	}
+	if f.FromDS {
+		ds |= 0x02
+	}
+	bssid := ""
+	if f.BSSID != nil {
+		bssid = f.BSSID.String()
+	}
+	fields := append([]string{
+		f.SA.String(),
+		optionalInt(f.Frequency),
+		optionalInt(f.Signal),
+		fmt.Sprintf("0x%04x", int(f.Type)<<4|int(f.Subtype)),
+		fmt.Sprintf("0x%02x", ds)},
+		elementFields(f.Elements)...)
+	return strings.Join(append(fields, bssid, cleanSSID(f.SSID)), "\t")
+}
+
+// cleanSSID keeps an SSID from breaking up the line it is written on.
+// SSIDs are arbitrary bytes, tabs and newlines included.
+func cleanSSID(ssid string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, ssid)
+}
+
+// elementFields formats a probe request's information elements as the
+// fingerprint fields of SharkFields.
+func elementFields(elems []capture.Element) []string {
+	var tags, rates, extRates, ht, vht, ouis []string
+	for _, e := range elems {
+		tags = append(tags, strconv.Itoa(int(e.ID)))
+		switch {
+		case e.ID == capture.ElementSupportedRates:
+			rates = append(rates, byteList(e.Data)...)
+		case e.ID == capture.ElementExtendedRates:
+			extRates = append(extRates, byteList(e.Data)...)
+		case e.ID == capture.ElementHTCapabilities && len(e.Data) >= 2:
+			ht = append(ht, fmt.Sprintf("0x%04x", binary.LittleEndian.Uint16(e.Data)))
+		case e.ID == capture.ElementVHTCapabilities && len(e.Data) >= 4:
+			vht = append(vht, fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(e.Data)))
+		case e.ID == capture.ElementVendor && len(e.Data) >= 3:
+			ouis = append(ouis, fmt.Sprintf("%02x:%02x:%02x", e.Data[0], e.Data[1], e.Data[2]))
+		}
+	}
+	fields := make([]string, 0)
+	for _, values := range [][]string{tags, rates, extRates, ht, vht, ouis} {
+		fields = append(fields, strings.Join(values, ","))
+	}
+	return fields
+}
+
+func byteList(data []byte) []string {
+	values := make([]string, 0)
+	for _, b := range data {
+		values = append(values, strconv.Itoa(int(b)))
+	}
+	return values
+}
+
+// nativeLine decodes a packet into a line, if it has a source address
+// and is in one of the `capture.frames` classes. This is the native
+// backend's stand-in for tshark's capture filter.
+func nativeLine(p capture.Packet) (string, bool) {
+	f, err := capture.Decode(p)
+	// Malformed frames are common over the air; skip them.
+	if err != nil || f.SA == nil {
+		return "", false
+	}
+	line := frameLine(f)
+	// As with captureFilter, beacons are kept when we are learning the
+	// library's BSSIDs from them.
+	s := ParseSighting(line)
+	if !wantFrame(s.Class) && (s.SSID == "" || len(state.GetLibrarySSIDs()) == 0) {
+		return "", false
+	}
+	return line, true
+}
+
+// optionalInt leaves a field blank, as tshark does, when the radiotap
+// header did not carry it.
+func optionalInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+// FrameLines drains a capture source and returns a line for every frame
+// with a source address, in the same form TSharkRunner returns them.
+func FrameLines(src capture.Source) []string {
+	macs := make([]string, 0)
+	for {
+		p, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("could not read from capture source")
+			break
+		}
+		if line, ok := nativeLine(p); ok {
+			macs = append(macs, line)
+		}
+	}
+	return macs
+}
+
+// GetSharkFn returns the SharkFn named by `capture.backend`.
+func GetSharkFn() SharkFn {
+	switch state.GetCaptureBackend() {
+	case "native":
+		return NativeRunner
+	case "tshark":
+		return TSharkRunner
+	default:
+		log.Warn().
+			Str("backend", state.GetCaptureBackend()).
+			Msg("unknown capture backend; using tshark")
+		return TSharkRunner
+	}
+}
@@ -0,0 +1,117 @@
+package tlp
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func captureFixture(name string) string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "..", "..", "..", "internal", "capture", "test", name)
+}
+
+func TestFrameLines(t *testing.T) {
+	for _, name := range []string{"capture.pcap", "capture.pcapng"} {
+		src, err := capture.OpenFile(captureFixture(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := FrameLines(src)
+		src.Close()
+		// The fingerprint fields are checked in TestFrameLinesFingerprint.
+		macs := make([]string, 0)
+		for _, line := range lines {
+			macs = append(macs, strings.Join(strings.Split(line, "\t")[:fingerprintFrom], "\t"))
+		}
+		expected := []string{
+			"00:11:22:33:44:55\t2437\t-40\t0x0008\t0x00",
+			"da:a1:19:00:00:01\t2437\t-70\t0x0004\t0x00",
+			"6e:00:00:00:00:02\t2437\t-72\t0x0004\t0x00",
+			"f0:18:98:00:00:01\t2412\t-55\t0x0004\t0x00",
+			"f0:18:98:00:00:01\t2437\t-50\t0x0020\t0x01",
+			"00:aa:bb:cc:dd:ee\t2437\t-45\t0x0020\t0x02",
+			"b8:27:eb:00:00:04\t5180\t-88\t0x0000\t0x00",
+		}
+		if !reflect.DeepEqual(macs, expected) {
+			t.Error(name, ": unexpected addresses ", macs)
+		}
+	}
+}
+
+func TestFrameLinesFingerprint(t *testing.T) {
+	src, err := capture.OpenFile(captureFixture("capture.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := FrameLines(src)
+	src.Close()
+	probe := strings.Split(lines[1], "\t")[fingerprintFrom:fingerprintTo]
+	expected := []string{"0,1,50,45,221", "2,4,11,22,12,18,24,36", "48,72,96,108", "0x0100", "", "00:50:f2"}
+	if !reflect.DeepEqual(probe, expected) {
+		t.Error("unexpected fingerprint fields ", probe)
+	}
+	if beacon := strings.Split(lines[0], "\t")[fingerprintFrom:fingerprintTo]; strings.Join(beacon, "") != "" {
+		t.Error("only probe requests should carry fingerprint fields ", beacon)
+	}
+}
+
+func TestFrameLinesNetwork(t *testing.T) {
+	src, err := capture.OpenFile(captureFixture("capture.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := FrameLines(src)
+	src.Close()
+	for i, expected := range [][]string{
+		{"00:11:22:33:44:55", "Library"}, // beacon
+		{"ff:ff:ff:ff:ff:ff", ""},        // probe request
+		{"00:11:22:33:44:55", ""},        // data, to the DS
+		{"00:11:22:33:44:55", ""},        // association request
+	} {
+		line := lines[[]int{0, 1, 4, 6}[i]]
+		if got := strings.Split(line, "\t")[bssidField:]; !reflect.DeepEqual(got, expected) {
+			t.Error("unexpected network fields ", got, " in ", line)
+		}
+	}
+}
+
+func TestGetSharkFn(t *testing.T) {
+	setup(t)
+	state.SetCaptureBackend("native")
+	if reflect.ValueOf(GetSharkFn()).Pointer() != reflect.ValueOf(NativeRunner).Pointer() {
+		t.Error("expected the native backend")
+	}
+	state.SetCaptureBackend("tshark")
+	if reflect.ValueOf(GetSharkFn()).Pointer() != reflect.ValueOf(TSharkRunner).Pointer() {
+		t.Error("expected the tshark backend")
+	}
+}
+
+func TestParseSighting(t *testing.T) {
+	for line, expected := range map[string]structs.Sighting{
+		"de:ad:be:ef:00:00":                    {MAC: "de:ad:be:ef:00:00", Randomized: true},
+		"de:ad:be:ef:00:00\t2437\n":            {MAC: "de:ad:be:ef:00:00", Randomized: true, Channel: 6},
+		"de:ad:be:ef:00:00\t5180,5180":         {MAC: "de:ad:be:ef:00:00", Randomized: true, Channel: 36},
+		"de:ad:be:ef:00:00\tgarbage":           {MAC: "de:ad:be:ef:00:00", Randomized: true},
+		"de:ad:be:ef:00:00\t2437\t-62,-60,-65": {MAC: "de:ad:be:ef:00:00", Randomized: true, Channel: 6, Signal: -62},
+		"de:ad:be:ef:00:00\t\t-62":             {MAC: "de:ad:be:ef:00:00", Randomized: true, Signal: -62},
+		"\t2437":                               {Channel: 6},
+		"00:11:22:33:44:55\t2437\t-40\t0x0008\t0x00\t\t\t\t\t\t\t00:11:22:33:44:55\tLibrary, upstairs": {
+			MAC: "00:11:22:33:44:55", Channel: 6, Signal: -40, Class: structs.FrameOther,
+			BSSID: "00:11:22:33:44:55", SSID: "Library, upstairs"},
+		"DE:AD:BE:EF:00:00\t2437\t-50\t0x0004\t0x00\t\t\t\t\t\t\tFF:FF:FF:FF:FF:FF\tLibrary": {
+			MAC: "DE:AD:BE:EF:00:00", Randomized: true, Channel: 6, Signal: -50, Class: structs.FrameProbe,
+			BSSID: "ff:ff:ff:ff:ff:ff"},
+	} {
+		if s := ParseSighting(line); s != expected {
+			t.Errorf("%q: expected %v, got %v", line, expected, s)
+		}
+	}
+}
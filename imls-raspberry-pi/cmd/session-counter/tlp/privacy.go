@@ -0,0 +1,226 @@
+package tlp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/interfaces"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// The statistics published under `privacy.mode` dp. Each spends half of
+// the session's budget.
+const (
+	StatisticDaily  = "daily_devices"
+	StatisticHourly = "hourly_devices"
+)
+
+var ErrBudgetSpent = errors.New("privacy budget for the session is spent")
+
+// uniform draws from [0, 1). It uses crypto/rand; noise an attacker can
+// predict is no noise at all.
+var uniform = func() float64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
+
+func laplace(scale float64) float64 {
+	u := uniform() - 0.5
+	for u == -0.5 {
+		u = uniform() - 0.5
+	}
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+func gaussian(sigma float64) float64 {
+	u1 := uniform()
+	for u1 == 0 {
+		u1 = uniform()
+	}
+	return sigma * math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*uniform())
+}
+
+// A noise is a mechanism calibrated for one statistic.
+type noise struct {
+	mechanism   string
+	epsilon     float64
+	delta       float64
+	sensitivity float64
+	scale       float64
+}
+
+// calibrate sets up noise for a statistic where one device changes at
+// most `buckets` counts, each by at most `per`. Laplace is scaled to the
+// L1 sensitivity; Gaussian to the L2 sensitivity, with the classic bound
+// (which assumes epsilon below 1).
+func calibrate(mechanism string, epsilon float64, delta float64, buckets int, per int) noise {
+	n := noise{mechanism: mechanism, epsilon: epsilon}
+	if mechanism == "gaussian" {
+		n.delta = delta
+		n.sensitivity = math.Sqrt(float64(buckets)) * float64(per)
+		n.scale = n.sensitivity * math.Sqrt(2*math.Log(1.25/delta)) / epsilon
+	} else {
+		n.sensitivity = float64(buckets * per)
+		n.scale = n.sensitivity / epsilon
+	}
+	return n
+}
+
+// add noises a count. Rounding and clamping at zero happen after the
+// noise, so they cost no privacy.
+func (n noise) add(count int) int {
+	var v float64
+	if n.mechanism == "gaussian" {
+		v = float64(count) + gaussian(n.scale)
+	} else {
+		v = float64(count) + laplace(n.scale)
+	}
+	if v < 0 {
+		return 0
+	}
+	return int(math.Round(v))
+}
+
+func (n noise) aggregate(period string, start time.Time, length time.Duration, count int) structs.Aggregate {
+	return structs.Aggregate{
+		PiSerial:    state.GetSerial(),
+		SessionID:   fmt.Sprint(state.GetCurrentSessionID()),
+		FCFSSeqID:   state.GetFCFSSeqID(),
+		DeviceTag:   state.GetDeviceTag(),
+		Period:      period,
+		Start:       start.Unix(),
+		Seconds:     int(length.Seconds()),
+		Count:       n.add(count),
+		Mechanism:   n.mechanism,
+		Epsilon:     n.epsilon,
+		Delta:       n.delta,
+		Sensitivity: n.sensitivity,
+		Scale:       n.scale,
+	}
+}
+
+func (n noise) spend(statistic string) structs.PrivacySpend {
+	return structs.PrivacySpend{
+		SessionID:   fmt.Sprint(state.GetCurrentSessionID()),
+		Statistic:   statistic,
+		Mechanism:   n.mechanism,
+		Epsilon:     n.epsilon,
+		Delta:       n.delta,
+		Sensitivity: n.sensitivity,
+		Timestamp:   state.GetClock().Now().Unix(),
+	}
+}
+
+// spendBudget records spending against the current session's budget in
+// the ledger, or refuses if it would take the session over
+// `privacy.epsilon`. Running ProcessData twice over a session (say, when
+// replaying) cannot publish it twice.
+func spendBudget(dDB interfaces.Database, spends []structs.PrivacySpend) error {
+	spent := []structs.PrivacySpend{}
+	// FIXME: Leaky Abstraction
+	err := dDB.GetPtr().Select(&spent, "SELECT * FROM privacyspends WHERE session_id=?",
+		fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		return err
+	}
+	total := 0.0
+	for _, s := range append(spent, spends...) {
+		total += s.Epsilon
+	}
+	// Allow for rounding in the halves.
+	if total > state.GetPrivacyEpsilon()*(1+1e-9) {
+		return ErrBudgetSpent
+	}
+	rows := make([]interface{}, 0)
+	for _, s := range spends {
+		rows = append(rows, s)
+	}
+	dDB.GetTableFromStruct(structs.PrivacySpend{}).InsertMany(rows)
+	return nil
+}
+
+// localHour is the start of the hour `t` falls in, in local time.
+func localHour(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
+}
+
+// publishAggregates counts the devices reported for a session, by hour
+// and for the whole day, and stores the counts with noise added. The
+// hours are always those of the 24 hours up to the reset, whether or not
+// anyone was there, so which hours are published gives nothing away
+// either.
+func publishAggregates(dDB interfaces.Database, devices []state.StartEnd) {
+	maxVisits := state.GetPrivacyMaxVisits()
+	maxHours := state.GetPrivacyMaxHours()
+	if maxVisits < 1 || maxHours < 1 || state.GetPrivacyEpsilon() <= 0 {
+		log.Error().
+			Int("max_visits", maxVisits).
+			Int("max_hours", maxHours).
+			Float64("epsilon", state.GetPrivacyEpsilon()).
+			Msg("privacy settings must be positive; publishing nothing")
+		return
+	}
+
+	mechanism := state.GetPrivacyMechanism()
+	half := state.GetPrivacyEpsilon() / 2
+	// The Gaussian calibration's bound does not hold from epsilon 1 up;
+	// noise from it would promise more privacy than it gives.
+	if mechanism == "gaussian" && half >= 1 {
+		log.Error().
+			Float64("epsilon", state.GetPrivacyEpsilon()).
+			Msg("the gaussian mechanism needs privacy.epsilon below 2; publishing nothing")
+		return
+	}
+	delta := state.GetPrivacyDelta() / 2
+	daily := calibrate(mechanism, half, delta, 1, maxVisits)
+	hourly := calibrate(mechanism, half, delta, maxVisits*maxHours, 1)
+	if err := spendBudget(dDB, []structs.PrivacySpend{
+		daily.spend(StatisticDaily), hourly.spend(StatisticHourly),
+	}); err != nil {
+		log.Error().
+			Err(err).
+			Int64("session", state.GetCurrentSessionID()).
+			Msg("not publishing aggregates")
+		return
+	}
+
+	now := state.GetClock().Now()
+	first := localHour(now.Add(-24 * time.Hour))
+	hours := make([]int, int(localHour(now).Sub(first)/time.Hour)+1)
+	total := 0
+	for _, se := range devices {
+		// Bound each device's part in the counts.
+		if se.Visit >= maxVisits {
+			continue
+		}
+		total += 1
+		counted := 0
+		for h := localHour(time.Unix(se.Start, 0)); !h.After(time.Unix(se.End, 0)) && counted < maxHours; h = h.Add(time.Hour) {
+			if ndx := int(h.Sub(first) / time.Hour); ndx >= 0 && ndx < len(hours) {
+				hours[ndx] += 1
+				counted += 1
+			}
+		}
+	}
+
+	aggregates := make([]interface{}, 0)
+	aggregates = append(aggregates, daily.aggregate(structs.PeriodDay, first, time.Duration(len(hours))*time.Hour, total))
+	for ndx, count := range hours {
+		start := first.Add(time.Duration(ndx) * time.Hour)
+		aggregates = append(aggregates, hourly.aggregate(structs.PeriodHour, start, time.Hour, count))
+	}
+	dDB.GetTableFromStruct(structs.Aggregate{}).InsertMany(aggregates)
+}
@@ -0,0 +1,170 @@
+package tlp
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestCalibrate(t *testing.T) {
+	n := calibrate("laplace", 0.5, 1e-6, 24, 1)
+	if n.sensitivity != 24 || n.scale != 48 || n.delta != 0 {
+		t.Error("unexpected laplace calibration ", n)
+	}
+	n = calibrate("gaussian", 0.5, 1e-6, 4, 2)
+	want := 4 * math.Sqrt(2*math.Log(1.25/1e-6)) / 0.5
+	if n.sensitivity != 4 || math.Abs(n.scale-want) > 1e-9 || n.delta != 1e-6 {
+		t.Error("unexpected gaussian calibration ", n)
+	}
+}
+
+func TestNoiseDistribution(t *testing.T) {
+	const draws = 50000
+	var lsum, labs, gsum, gsq float64
+	for i := 0; i < draws; i++ {
+		l := laplace(2)
+		lsum += l
+		labs += math.Abs(l)
+		g := gaussian(2)
+		gsum += g
+		gsq += g * g
+	}
+	// Laplace(b) has mean 0 and mean absolute value b; N(0, s) has
+	// variance s^2.
+	if math.Abs(lsum/draws) > 0.1 || math.Abs(labs/draws-2) > 0.1 {
+		t.Error("laplace noise looks off: mean ", lsum/draws, " mean |x| ", labs/draws)
+	}
+	if math.Abs(gsum/draws) > 0.1 || math.Abs(gsq/draws-4) > 0.2 {
+		t.Error("gaussian noise looks off: mean ", gsum/draws, " variance ", gsq/draws)
+	}
+}
+
+func TestNoiseRoundsAndClamps(t *testing.T) {
+	defer func(u func() float64) { uniform = u }(uniform)
+	n := calibrate("laplace", 1, 0, 1, 1)
+	uniform = func() float64 { return 0.5 }
+	if c := n.add(7); c != 7 {
+		t.Error("no noise should leave the count alone, got ", c)
+	}
+	uniform = func() float64 { return 0.001 }
+	if c := n.add(2); c != 0 {
+		t.Error("noisy counts should never go below zero, got ", c)
+	}
+}
+
+func sessionAggregates(t *testing.T) []structs.Aggregate {
+	aggregates := []structs.Aggregate{}
+	err := state.GetDurationsDatabase().GetPtr().Select(&aggregates,
+		"SELECT * FROM aggregates WHERE session_id=? ORDER BY start, period", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aggregates
+}
+
+func TestPublishAggregates(t *testing.T) {
+	setup(t)
+	state.SetPrivacyMode("dp")
+	defer state.SetPrivacyMode("off")
+	// No noise, so the counts can be checked.
+	defer func(u func() float64) { uniform = u }(uniform)
+	uniform = func() float64 { return 0.5 }
+
+	durations := processFixture(t)
+	aggregates := sessionAggregates(t)
+	// The day, then one per hour of the 24 hours up to and including
+	// the reset's.
+	if len(aggregates) != 26 {
+		t.Fatal("expected 26 aggregates, found ", len(aggregates))
+	}
+	day, hours := aggregates[0], aggregates[1:]
+	if day.Period != structs.PeriodDay || day.Count != len(durations) || day.Seconds != 25*3600 {
+		t.Error("unexpected daily aggregate ", day)
+	}
+	if day.Epsilon != 0.5 || day.Sensitivity != 2 || day.Scale != 4 || day.Mechanism != "laplace" {
+		t.Error("the noise parameters should be recorded with the count ", day)
+	}
+	// Every sighting in the fixture is stamped with the mock clock, which
+	// is the reset's hour.
+	last := hours[len(hours)-1]
+	if last.Count != len(durations) || last.Start != localHour(state.GetClock().Now()).Unix() {
+		t.Error("unexpected last hour ", last)
+	}
+	for _, h := range hours[:len(hours)-1] {
+		if h.Count != 0 || h.Seconds != 3600 || h.Sensitivity != 24 {
+			t.Error("unexpected hour ", h)
+		}
+	}
+
+	// Running it again over the same session would spend the budget
+	// twice.
+	publishAggregates(state.GetDurationsDatabase(), []state.StartEnd{{}})
+	if n := len(sessionAggregates(t)); n != 26 {
+		t.Error("expected the second run to be refused, found ", n, " aggregates")
+	}
+	ledger := []structs.PrivacySpend{}
+	state.GetDurationsDatabase().GetPtr().Select(&ledger,
+		"SELECT * FROM privacyspends WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if len(ledger) != 2 || ledger[0].Epsilon+ledger[1].Epsilon != state.GetPrivacyEpsilon() {
+		t.Error("unexpected ledger ", ledger)
+	}
+}
+
+func TestPublishAggregatesGaussianEpsilon(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	state.SetPrivacyMechanism("gaussian")
+	defer state.SetPrivacyMechanism("laplace")
+	defer state.SetPrivacyEpsilon(state.GetPrivacyEpsilon())
+
+	state.SetPrivacyEpsilon(2)
+	publishAggregates(state.GetDurationsDatabase(), []state.StartEnd{{}})
+	if n := len(sessionAggregates(t)); n != 0 {
+		t.Error("expected nothing published with epsilon 2, found ", n, " aggregates")
+	}
+	ledger := []structs.PrivacySpend{}
+	state.GetDurationsDatabase().GetPtr().Select(&ledger,
+		"SELECT * FROM privacyspends WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if len(ledger) != 0 {
+		t.Error("a refused publish should not spend the budget ", ledger)
+	}
+
+	state.SetPrivacyEpsilon(1.5)
+	publishAggregates(state.GetDurationsDatabase(), []state.StartEnd{{}})
+	aggregates := sessionAggregates(t)
+	if len(aggregates) != 26 || aggregates[0].Mechanism != "gaussian" || aggregates[0].Epsilon != 0.75 {
+		t.Error("expected gaussian aggregates with epsilon 1.5, found ", aggregates)
+	}
+}
+
+func TestPublishAggregatesBoundsVisits(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	defer func(u func() float64) { uniform = u }(uniform)
+	uniform = func() float64 { return 0.5 }
+
+	now := state.GetClock().Now().Unix()
+	publishAggregates(state.GetDurationsDatabase(), []state.StartEnd{
+		// In for the whole day, but only counted for `privacy.max_hours`.
+		{Start: now - 23*3600, End: now},
+		{Start: now - 3600, End: now, Visit: 1},
+		// A third visit by the same device is not counted at all.
+		{Start: now, End: now, Visit: 2},
+	})
+	total := 0
+	for _, a := range sessionAggregates(t) {
+		if a.Period == structs.PeriodDay {
+			if a.Count != 2 {
+				t.Error("expected two visits in the daily count, got ", a.Count)
+			}
+		} else {
+			total += a.Count
+		}
+	}
+	if total != state.GetPrivacyMaxHours()+2 {
+		t.Error("expected the long visit to be clipped, found ", total, " device-hours")
+	}
+}
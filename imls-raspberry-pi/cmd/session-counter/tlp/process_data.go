@@ -23,9 +23,38 @@ func ProcessData(dDB interfaces.Database, sq *state.Queue, iq *state.Queue) bool
 	}
 
 	pidCounter := 0
-	durations := make([]interface{}, 0)
+	reportable := make([]structs.Duration, 0)
+
+	// The manufacturer categories were looked up when the MACs were
+	// stored; the MACs themselves are hashed. Visits still going on are
+	// dealt with under `reset.boundary`.
+	devices, carry := atBoundary(state.GetMACs(), state.GetClock().Now().Unix())
+	state.CarryOver(carry)
 
-	for _, se := range state.GetMACs() {
+	mode := state.GetNetworkMode()
+	g := granularity()
+	all, library := 0, 0
+	reported := make([]state.StartEnd, 0)
+	for _, se := range applyRandomizedPolicy(devices) {
+		all += 1
+		if se.Library {
+			library += 1
+		} else if mode == "library" {
+			continue
+		}
+		reported = append(reported, se)
+		randomized, onNetwork, truncated := 0, 0, 0
+		if se.Randomized {
+			randomized = 1
+		}
+		if se.Library {
+			onNetwork = 1
+		}
+		if se.Truncated {
+			truncated = 1
+		}
+		// No more precise than any analysis needs.
+		start, end := coarsen(se.Start, se.End, g)
 
 		d := structs.Duration{
 			PiSerial:  state.GetSerial(),
@@ -34,14 +63,96 @@ func ProcessData(dDB interfaces.Database, sq *state.Queue, iq *state.Queue) bool
 			DeviceTag: state.GetDeviceTag(),
 			PatronID:  pidCounter,
 			// FIXME: All times should become UNIX epoch seconds...
-			Start: se.Start,
-			End:   se.End}
+			Start:       start,
+			End:         end,
+			ClientClass: structs.ClientClass(se.Class),
+			Randomized:  randomized,
+			// The coarse oui category, never the manufacturer itself.
+			ManufacturerIndex: se.Manufacturer,
+			Library:           onNetwork,
+			Granularity:       int(g),
+			Truncated:         truncated}
 
 		//dDB.GetTableFromStruct(structs.Duration{}).InsertStruct(d)
-		durations = append(durations, d)
+		reportable = append(reportable, d)
 		pidCounter += 1
 	}
 
+	// Small buckets are dealt with before the durations are stored, so
+	// neither the upload nor the images see them.
+	kept, held := protectSmallCounts(reportable)
+	durations := make([]interface{}, 0)
+	for _, d := range kept {
+		durations = append(durations, d)
+	}
 	dDB.GetTableFromStruct(structs.Duration{}).InsertMany(durations)
+
+	// Only the noisy counts leave the Pi in this mode.
+	if state.GetPrivacyMode() == "dp" {
+		publishAggregates(dDB, reported)
+	}
+
+	dDB.GetTableFromStruct(structs.NetworkTotal{}).InsertStruct(structs.NetworkTotal{
+		PiSerial:       state.GetSerial(),
+		SessionID:      fmt.Sprint(state.GetCurrentSessionID()),
+		FCFSSeqID:      state.GetFCFSSeqID(),
+		DeviceTag:      state.GetDeviceTag(),
+		NetworkMode:    mode,
+		AllDevices:     all,
+		LibraryDevices: library,
+	})
+
+	// Per-channel counts only exist when we are hopping channels.
+	counts := make([]interface{}, 0)
+	for channel, cs := range state.GetChannelStats() {
+		counts = append(counts, structs.ChannelCount{
+			PiSerial:  state.GetSerial(),
+			SessionID: fmt.Sprint(state.GetCurrentSessionID()),
+			FCFSSeqID: state.GetFCFSSeqID(),
+			DeviceTag: state.GetDeviceTag(),
+			Channel:   channel,
+			Sightings: cs.Sightings,
+			Devices:   cs.Devices,
+		})
+	}
+	if len(counts) > 0 {
+		dDB.GetTableFromStruct(structs.ChannelCount{}).InsertMany(counts)
+	}
+
+	adapters := make([]interface{}, 0)
+	for adapter, as := range state.GetAdapterStats() {
+		adapters = append(adapters, structs.AdapterCount{
+			PiSerial:  state.GetSerial(),
+			SessionID: fmt.Sprint(state.GetCurrentSessionID()),
+			FCFSSeqID: state.GetFCFSSeqID(),
+			DeviceTag: state.GetDeviceTag(),
+			Adapter:   adapter,
+			Sightings: as.Sightings,
+			Devices:   as.Devices,
+		})
+	}
+	if len(adapters) > 0 {
+		dDB.GetTableFromStruct(structs.AdapterCount{}).InsertMany(adapters)
+	}
+
+	// One coverage summary per session, so a quiet day can be told
+	// apart from a day the sensor was not capturing.
+	scans := []structs.ScanStat{}
+	// FIXME: Leaky Abstraction
+	err := dDB.GetPtr().Select(&scans, "SELECT * FROM scanstats WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not read scan statistics")
+	}
+	coverage := summarizeScans(scans)
+	coverage.PiSerial = state.GetSerial()
+	coverage.SessionID = fmt.Sprint(state.GetCurrentSessionID())
+	coverage.FCFSSeqID = state.GetFCFSSeqID()
+	coverage.DeviceTag = state.GetDeviceTag()
+	coverage.KThreshold = state.GetPrivacyK()
+	coverage.SuppressedDurations = held.suppressed
+	coverage.MergedBuckets = held.merged
+	dDB.GetTableFromStruct(structs.Coverage{}).InsertStruct(coverage)
 	return true
 }
@@ -0,0 +1,87 @@
+package tlp
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"gsa.gov/18f/internal/oui"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// processFixture runs the capture fixture through StoreMacs and
+// ProcessData, and returns the durations that were written.
+func processFixture(t *testing.T) []structs.Duration {
+	cleanupTempFiles()
+	storeFixture(t)
+	db := state.GetDurationsDatabase()
+	ProcessData(db, state.NewQueue("sent"), state.NewQueue("images"))
+	durations := []structs.Duration{}
+	err := db.GetPtr().Select(&durations, "SELECT * FROM durations WHERE session_id=?",
+		fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return durations
+}
+
+func TestProcessDataManufacturers(t *testing.T) {
+	setup(t)
+	categories := make(map[int]int)
+	for _, d := range processFixture(t) {
+		categories[d.ManufacturerIndex] += 1
+	}
+	// The phone and the Pi; the rest are randomized or not in the table.
+	if categories[oui.Phone] != 1 || categories[oui.IoT] != 1 || categories[oui.Unknown] != 4 {
+		t.Error("unexpected manufacturer categories ", categories)
+	}
+}
+
+func TestLoadManufacturerTable(t *testing.T) {
+	fh, err := os.CreateTemp("", "oui-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name())
+	fh.WriteString("Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-L,00AABB,Sonos Inc.,Somewhere\n")
+	fh.Close()
+
+	if c := loadManufacturerTable(fh.Name()).Category("00:aa:bb:cc:dd:ee"); c != oui.IoT {
+		t.Error("expected the table from oui.path to be used, got ", c)
+	}
+	if loadManufacturerTable("/no/such/oui.csv").Len() != oui.Embedded().Len() {
+		t.Error("expected a missing table to fall back to the embedded one")
+	}
+}
+
+func TestProcessDataLibraryNetwork(t *testing.T) {
+	setup(t)
+	state.SetLibrarySSIDs("Library")
+	defer state.SetLibrarySSIDs("")
+	state.SetNetworkMode("library")
+	defer state.SetNetworkMode("all")
+
+	// The phone sends data through the library's access point, and the
+	// Pi associates with it; everyone else only probes or beacons.
+	durations := processFixture(t)
+	if len(durations) != 2 {
+		t.Fatal("expected only the devices on the library's network, found ", len(durations))
+	}
+	for _, d := range durations {
+		if d.Library != 1 {
+			t.Error("expected every duration to be on the library's network")
+		}
+	}
+
+	totals := []structs.NetworkTotal{}
+	err := state.GetDurationsDatabase().GetPtr().Select(&totals,
+		"SELECT * FROM networktotals WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].AllDevices != 6 || totals[0].LibraryDevices != 2 {
+		t.Error("unexpected totals ", totals)
+	}
+}
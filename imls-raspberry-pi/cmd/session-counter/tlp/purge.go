@@ -0,0 +1,160 @@
+package tlp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/interfaces"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// recordUpload notes that the API has one kind of a session's rows, so
+// they can be purged once they are old enough.
+func recordUpload(db interfaces.Database, session string, kind string) {
+	db.GetTableFromStruct(structs.Upload{}).InsertStruct(structs.Upload{
+		SessionID: session,
+		Kind:      kind,
+		Timestamp: state.GetClock().Now().Unix(),
+	})
+}
+
+// imageSession is the session an image was drawn for, from its name
+// (see writeImages).
+func imageSession(name string) string {
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// Purge deletes what the retention settings say is old enough to go, and
+// records what it deleted in the purges table. Durations, aggregates and
+// coverage are only purged once the API has confirmed that kind of row
+// for the session, so under `privacy.mode` dp the durations, which are
+// never sent, are kept. What is only ever kept here (the scans, the
+// counts, and the images) goes once anything of the session was
+// uploaded. In local mode, nothing is ever uploaded, so nothing is ever
+// purged. The privacy budget ledger is never purged.
+func Purge(db interfaces.Database) {
+	uploads := []structs.Upload{}
+	// FIXME: Leaky Abstraction
+	err := db.GetPtr().Select(&uploads, "SELECT * FROM uploads")
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not find uploaded sessions to purge")
+		return
+	}
+
+	now := state.GetClock().Now()
+	expired := func(days int, uploaded int64) bool {
+		return days > 0 && !now.Before(time.Unix(uploaded, 0).Add(time.Duration(days)*24*time.Hour))
+	}
+	purges := make([]interface{}, 0)
+	// A session can be uploaded more than once (when replaying); it is
+	// old when it was first uploaded. byKind is keyed by Upload kind,
+	// and uploaded is of any kind.
+	byKind := make(map[string]map[string]int64)
+	uploaded := make(map[string]int64)
+	first := func(m map[string]int64, session string, t int64) {
+		if was, ok := m[session]; !ok || t < was {
+			m[session] = t
+		}
+	}
+	for _, u := range uploads {
+		kinds := []string{u.Kind}
+		// Recorded before uploads had kinds, once the whole session
+		// was up.
+		if u.Kind == "" {
+			kinds = []string{uploadKind(), structs.UploadCoverage}
+		}
+		for _, kind := range kinds {
+			if byKind[kind] == nil {
+				byKind[kind] = make(map[string]int64)
+			}
+			first(byKind[kind], u.SessionID, u.Timestamp)
+		}
+		first(uploaded, u.SessionID, u.Timestamp)
+	}
+
+	for _, table := range []struct {
+		name string
+		// Blank for the tables that are never sent.
+		kind string
+		days int
+	}{
+		{"durations", structs.UploadDurations, state.GetRetentionDurations()},
+		{"coverages", structs.UploadCoverage, state.GetRetentionDurations()},
+		{"aggregates", structs.UploadAggregates, state.GetRetentionDurations()},
+		{"networktotals", "", state.GetRetentionDurations()},
+		{"channelcounts", "", state.GetRetentionDurations()},
+		{"adaptercounts", "", state.GetRetentionDurations()},
+		{"scanstats", "", state.GetRetentionScanStats()},
+	} {
+		sessions := uploaded
+		if table.kind != "" {
+			sessions = byKind[table.kind]
+		}
+		for session, t := range sessions {
+			if !expired(table.days, t) {
+				continue
+			}
+			res, err := db.GetPtr().Exec(fmt.Sprintf("DELETE FROM %s WHERE session_id=?", table.name), session)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("table", table.name).
+					Str("session", session).
+					Msg("could not purge")
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				purges = append(purges, structs.Purge{
+					SessionID: session,
+					Table:     table.name,
+					Rows:      int(n),
+					Timestamp: now.Unix(),
+				})
+			}
+		}
+	}
+
+	images, _ := filepath.Glob(filepath.Join(state.GetWWWImages(), "*.png"))
+	removed := make(map[string]int)
+	for _, image := range images {
+		session := imageSession(filepath.Base(image))
+		t, ok := uploaded[session]
+		if !ok || !expired(state.GetRetentionImages(), t) {
+			continue
+		}
+		if err := os.Remove(image); err != nil {
+			log.Error().
+				Err(err).
+				Str("image", image).
+				Msg("could not purge")
+			continue
+		}
+		removed[session] += 1
+	}
+	for session, n := range removed {
+		purges = append(purges, structs.Purge{
+			SessionID: session,
+			Table:     structs.PurgedImages,
+			Rows:      n,
+			Timestamp: now.Unix(),
+		})
+	}
+
+	if len(purges) > 0 {
+		db.GetTableFromStruct(structs.Purge{}).InsertMany(purges)
+	}
+	log.Info().
+		Int("purged", len(purges)).
+		Msg("purged old uploaded data")
+}
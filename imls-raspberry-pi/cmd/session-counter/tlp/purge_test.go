@@ -0,0 +1,162 @@
+package tlp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func count(t *testing.T, table string, session string) int {
+	var n int
+	err := state.GetDurationsDatabase().GetPtr().QueryRow("SELECT COUNT(*) FROM "+table+" WHERE session_id=?", session).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPurge(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	mock := state.GetClock().(*clock.Mock)
+	state.SetRetentionDurations(7)
+	state.SetRetentionScanStats(2)
+	state.SetRetentionImages(7)
+	defer func() {
+		state.SetRetentionDurations(0)
+		state.SetRetentionScanStats(0)
+		state.SetRetentionImages(0)
+	}()
+
+	db := state.GetDurationsDatabase()
+	for _, session := range []string{"1", "2", "3"} {
+		db.GetTableFromStruct(structs.Duration{}).InsertStruct(structs.Duration{SessionID: session})
+		db.GetTableFromStruct(structs.ScanStat{}).InsertStruct(structs.ScanStat{SessionID: session, Outcome: structs.ScanOK})
+		db.GetTableFromStruct(structs.Coverage{}).InsertStruct(structs.Coverage{SessionID: session})
+		db.GetTableFromStruct(structs.NetworkTotal{}).InsertStruct(structs.NetworkTotal{SessionID: session})
+		db.GetTableFromStruct(structs.ChannelCount{}).InsertStruct(structs.ChannelCount{SessionID: session})
+		db.GetTableFromStruct(structs.AdapterCount{}).InsertStruct(structs.AdapterCount{SessionID: session})
+		ioutil.WriteFile(filepath.Join(state.GetWWWImages(), "19751011-"+session+"-ME0000-001_testing.png"), []byte{}, 0644)
+	}
+	// Session 1 went up eight days ago, session 2 three days ago, and
+	// session 3 has not been uploaded.
+	recordUpload(db, "1", structs.UploadDurations)
+	recordUpload(db, "1", structs.UploadCoverage)
+	mock.Add(5 * 24 * time.Hour)
+	recordUpload(db, "2", structs.UploadDurations)
+	recordUpload(db, "2", structs.UploadCoverage)
+	mock.Add(3 * 24 * time.Hour)
+
+	Purge(db)
+	for _, c := range []struct {
+		table   string
+		session string
+		want    int
+	}{
+		{"durations", "1", 0},
+		{"coverages", "1", 0},
+		{"networktotals", "1", 0},
+		{"channelcounts", "1", 0},
+		{"adaptercounts", "1", 0},
+		{"scanstats", "1", 0},
+		{"durations", "2", 1},
+		{"coverages", "2", 1},
+		{"networktotals", "2", 1},
+		{"channelcounts", "2", 1},
+		{"adaptercounts", "2", 1},
+		{"scanstats", "2", 0},
+		{"durations", "3", 1},
+		{"scanstats", "3", 1},
+	} {
+		if got := count(t, c.table, c.session); got != c.want {
+			t.Error("expected ", c.want, " rows in ", c.table, " for session ", c.session, ", found ", got)
+		}
+	}
+	images, _ := filepath.Glob(filepath.Join(state.GetWWWImages(), "*.png"))
+	if len(images) != 2 {
+		t.Error("expected only the image for session 1 to be purged, found ", images)
+	}
+	if _, err := os.Stat(filepath.Join(state.GetWWWImages(), "19751011-1-ME0000-001_testing.png")); !os.IsNotExist(err) {
+		t.Error("the image for session 1 should be purged")
+	}
+
+	purges := []structs.Purge{}
+	if err := db.GetPtr().Select(&purges, "SELECT * FROM purges ORDER BY session_id, table_name"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		session string
+		table   string
+	}{
+		{"1", "adaptercounts"}, {"1", "channelcounts"}, {"1", "coverages"}, {"1", "durations"},
+		{"1", structs.PurgedImages}, {"1", "networktotals"}, {"1", "scanstats"}, {"2", "scanstats"},
+	}
+	if len(purges) != len(expected) {
+		t.Fatal("unexpected purges ", purges)
+	}
+	for ndx, e := range expected {
+		if purges[ndx].SessionID != e.session || purges[ndx].Table != e.table || purges[ndx].Rows != 1 {
+			t.Error("expected a purge of ", e, ", got ", purges[ndx])
+		}
+	}
+
+	// Nothing more to do.
+	Purge(db)
+	if count(t, "purges", "1") != 7 {
+		t.Error("a second purge should not record anything")
+	}
+}
+
+func TestPurgeDP(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	mock := state.GetClock().(*clock.Mock)
+	state.SetPrivacyMode("dp")
+	state.SetRetentionDurations(7)
+	state.SetRetentionScanStats(2)
+	defer func() {
+		state.SetPrivacyMode("off")
+		state.SetRetentionDurations(0)
+		state.SetRetentionScanStats(0)
+	}()
+
+	db := state.GetDurationsDatabase()
+	for _, session := range []string{"1", "2"} {
+		db.GetTableFromStruct(structs.Duration{}).InsertStruct(structs.Duration{SessionID: session})
+		db.GetTableFromStruct(structs.Aggregate{}).InsertStruct(structs.Aggregate{SessionID: session})
+		db.GetTableFromStruct(structs.Coverage{}).InsertStruct(structs.Coverage{SessionID: session})
+		db.GetTableFromStruct(structs.ScanStat{}).InsertStruct(structs.ScanStat{SessionID: session, Outcome: structs.ScanOK})
+	}
+	// Only the aggregates and coverage of session 1 went up. Session 2
+	// was uploaded before uploads had kinds.
+	recordUpload(db, "1", structs.UploadAggregates)
+	recordUpload(db, "1", structs.UploadCoverage)
+	recordUpload(db, "2", "")
+	mock.Add(8 * 24 * time.Hour)
+
+	Purge(db)
+	for _, c := range []struct {
+		table   string
+		session string
+		want    int
+	}{
+		// The durations were never sent.
+		{"durations", "1", 1},
+		{"aggregates", "1", 0},
+		{"coverages", "1", 0},
+		{"scanstats", "1", 0},
+		{"durations", "2", 1},
+		{"aggregates", "2", 0},
+		{"coverages", "2", 0},
+	} {
+		if got := count(t, c.table, c.session); got != c.want {
+			t.Error("expected ", c.want, " rows in ", c.table, " for session ", c.session, ", found ", got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package tlp
+
+import (
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+)
+
+func shortLived(se state.StartEnd) bool {
+	return se.Randomized &&
+		se.End-se.Start < int64(state.GetRandomizedShortMinutes()*60)
+}
+
+// applyRandomizedPolicy applies `randomized.policy` to the devices seen in
+// a session. Devices with global MACs, and randomized MACs that stuck
+// around, are always kept as they are.
+func applyRandomizedPolicy(devices []state.StartEnd) []state.StartEnd {
+	policy := state.GetRandomizedPolicy()
+	if policy == "count" {
+		return devices
+	}
+
+	kept := make([]state.StartEnd, 0)
+	short := make([]state.StartEnd, 0)
+	for _, se := range devices {
+		if shortLived(se) {
+			short = append(short, se)
+		} else {
+			kept = append(kept, se)
+		}
+	}
+
+	log.Debug().
+		Str("policy", policy).
+		Int("short-lived", len(short)).
+		Int("kept", len(kept)).
+		Msg("applying randomized MAC policy")
+
+	if policy == "discount" {
+		return kept
+	}
+	return append(kept, collapse(short)...)
+}
+
+// collapse chains short-lived randomized MACs that follow one another
+// closely, on the theory that they are one phone rotating its address.
+// Each MAC goes onto the chain that went quiet most recently before it
+// appeared, if that was within `randomized.collapse_gap`.
+func collapse(short []state.StartEnd) []state.StartEnd {
+	sort.Slice(short, func(i, j int) bool {
+		return short[i].Start < short[j].Start
+	})
+	gap := int64(state.GetRandomizedCollapseGap() * 60)
+	chains := make([]state.StartEnd, 0)
+	for _, se := range short {
+		best := -1
+		for ndx, c := range chains {
+			if c.End <= se.Start && se.Start-c.End <= gap &&
+				(best < 0 || c.End > chains[best].End) {
+				best = ndx
+			}
+		}
+		if best < 0 {
+			chains = append(chains, se)
+		} else {
+			chains[best] = chains[best].Extend(se)
+		}
+	}
+	return chains
+}
@@ -0,0 +1,56 @@
+package tlp
+
+import (
+	"testing"
+
+	"gsa.gov/18f/internal/state"
+)
+
+// A phone rotating through three addresses, one after the other, a
+// passerby, a long-lived randomized MAC, and a laptop with a global MAC.
+var randomizedDevices = []state.StartEnd{
+	{Start: 0, End: 120, Randomized: true},
+	{Start: 180, End: 240, Randomized: true},
+	{Start: 300, End: 420, Randomized: true},
+	{Start: 3000, End: 3060, Randomized: true},
+	{Start: 0, End: 3600, Randomized: true},
+	{Start: 60, End: 90},
+}
+
+func TestRandomizedCount(t *testing.T) {
+	setup(t)
+	state.SetRandomizedPolicy("count")
+	if n := len(applyRandomizedPolicy(randomizedDevices)); n != 6 {
+		t.Error("expected every device to be counted, got ", n)
+	}
+}
+
+func TestRandomizedDiscount(t *testing.T) {
+	setup(t)
+	state.SetRandomizedPolicy("discount")
+	defer state.SetRandomizedPolicy("count")
+	devices := applyRandomizedPolicy(randomizedDevices)
+	if len(devices) != 2 {
+		t.Fatal("expected only the long-lived and global devices, got ", devices)
+	}
+}
+
+func TestRandomizedCollapse(t *testing.T) {
+	setup(t)
+	state.SetRandomizedPolicy("collapse")
+	defer state.SetRandomizedPolicy("count")
+	devices := applyRandomizedPolicy(randomizedDevices)
+	if len(devices) != 4 {
+		t.Fatal("expected the phone's addresses to collapse, got ", devices)
+	}
+	phone := state.StartEnd{Start: 0, End: 420, Randomized: true}
+	found := false
+	for _, se := range devices {
+		if se == phone {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected one device from 0 to 420, got ", devices)
+	}
+}
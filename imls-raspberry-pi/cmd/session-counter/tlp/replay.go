@@ -0,0 +1,151 @@
+package tlp
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
+)
+
+// The adapter name handed to SimpleShark during a replay.
+const ReplayAdapter = "replay0"
+
+type sighting struct {
+	when time.Time
+	line string
+}
+
+// CaptureFiles expands a file or directory into the list of capture files
+// to replay, in name order.
+func CaptureFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".pcap" || ext == ".pcapng" || ext == ".cap") {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no capture files found in " + path)
+	}
+	return files, nil
+}
+
+func readSightings(files []string) ([]sighting, error) {
+	sightings := make([]sighting, 0)
+	for _, file := range files {
+		src, err := capture.OpenFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			p, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("file", file).
+					Msg("could not read capture; skipping the rest of the file")
+				break
+			}
+			f, err := capture.Decode(p)
+			if err != nil || f.SA == nil {
+				continue
+			}
+			sightings = append(sightings, sighting{when: p.Timestamp, line: frameLine(f)})
+		}
+		src.Close()
+	}
+	sort.SliceStable(sightings, func(i, j int) bool {
+		return sightings[i].when.Before(sightings[j].when)
+	})
+	return sightings, nil
+}
+
+// Replay runs recorded captures through SimpleShark on a mock clock. The
+// frames are batched by minute, as if the capture cron had fired once per
+// minute, and `reset` is called whenever the clock crosses the reset cron
+// (and once more at the end, so the last day is processed too). If `start`
+// is not zero, packet timestamps are shifted so the first one lands on it.
+func Replay(files []string, start time.Time, reset func()) error {
+	sightings, err := readSightings(files)
+	if err != nil {
+		return err
+	}
+	if len(sightings) == 0 {
+		return errors.New("no frames with source addresses to replay")
+	}
+	schedule, err := cron.ParseStandard(state.GetResetCron())
+	if err != nil {
+		return err
+	}
+
+	offset := time.Duration(0)
+	if !start.IsZero() {
+		offset = start.Sub(sightings[0].when)
+	}
+
+	mock := clock.NewMock()
+	first := sightings[0].when.Add(offset).Truncate(time.Minute)
+	mock.Set(first)
+	state.SetClock(mock)
+	state.IncrementSessionID()
+	nextReset := schedule.Next(first)
+
+	log.Info().
+		Int("frames", len(sightings)).
+		Str("start", first.In(time.Local).String()).
+		Msg("starting replay")
+
+	for ndx := 0; ndx < len(sightings); {
+		minute := sightings[ndx].when.Add(offset).Truncate(time.Minute)
+		macs := make([]string, 0)
+		for ; ndx < len(sightings); ndx++ {
+			if !sightings[ndx].when.Add(offset).Truncate(time.Minute).Equal(minute) {
+				break
+			}
+			macs = append(macs, sightings[ndx].line)
+		}
+
+		for !nextReset.After(minute) {
+			mock.Set(nextReset)
+			reset()
+			nextReset = schedule.Next(nextReset)
+		}
+
+		mock.Set(minute)
+		SimpleShark(
+			func(*models.Device) error { return nil },
+			func() *models.Device {
+				return &models.Device{Exists: true, Logicalname: ReplayAdapter}
+			},
+			func(string) ([]string, error) { return macs, nil })
+	}
+
+	reset()
+	return nil
+}
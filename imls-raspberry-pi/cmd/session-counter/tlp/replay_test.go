@@ -0,0 +1,64 @@
+package tlp
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gsa.gov/18f/internal/state"
+)
+
+func TestReplay(t *testing.T) {
+	setup(t)
+	resets := 0
+	var snapshot state.EphemeralDB
+	err := Replay([]string{captureFixture("capture.pcap")}, time.Time{}, func() {
+		resets += 1
+		snapshot = state.GetMACs()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resets != 1 {
+		t.Error("expected a single reset at the end, got ", resets)
+	}
+	if len(snapshot) != 6 {
+		t.Error("expected 6 devices, found ", len(snapshot))
+	}
+	// The phone probes at 10:00:20 and sends data at 10:01:05.
+	phone := snapshot[state.HashMAC("f0:18:98:00:00:01")]
+	first := time.Date(2022, 5, 18, 10, 0, 0, 0, time.UTC)
+	if phone.Start != first.Unix() || phone.End != first.Add(time.Minute).Unix() {
+		t.Error("unexpected phone session ", phone)
+	}
+}
+
+func TestReplayShiftedAcrossReset(t *testing.T) {
+	setup(t)
+	state.SetResetCron("0 0 * * *")
+	// Shift the capture so it straddles midnight.
+	start := time.Date(1975, 10, 11, 23, 59, 30, 0, time.Local)
+	resets := make([]int, 0)
+	err := Replay([]string{captureFixture("capture.pcapng")}, start, func() {
+		resets = append(resets, len(state.GetMACs()))
+		state.ClearEphemeralDB()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames at :30, :35, :40 and :50 fall before midnight: the AP, both
+	// randomized probers and the phone. The rest land in the next session.
+	if len(resets) != 2 || resets[0] != 4 || resets[1] != 3 {
+		t.Error("unexpected sessions ", resets)
+	}
+}
+
+func TestCaptureFiles(t *testing.T) {
+	files, err := CaptureFiles(filepath.Dir(captureFixture("capture.pcap")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Error("expected both fixtures, found ", files)
+	}
+}
@@ -0,0 +1,55 @@
+package tlp
+
+import (
+	"fmt"
+	"time"
+
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// recordScan writes a ScanStat row for one capture on an adapter, from
+// `started` until now.
+// NOTE: Do not log MAC addresses.
+func recordScan(adapter string, started time.Time, sightings []structs.Sighting, outcome string) {
+	devices := make(map[string]bool)
+	for _, s := range sightings {
+		devices[s.MAC] = true
+	}
+	state.GetDurationsDatabase().GetTableFromStruct(structs.ScanStat{}).InsertStruct(structs.ScanStat{
+		PiSerial:  state.GetSerial(),
+		SessionID: fmt.Sprint(state.GetCurrentSessionID()),
+		FCFSSeqID: state.GetFCFSSeqID(),
+		DeviceTag: state.GetDeviceTag(),
+		Timestamp: started.Unix(),
+		Adapter:   adapter,
+		Frames:    len(sightings),
+		Devices:   len(devices),
+		Seconds:   int(state.GetClock().Now().Sub(started).Seconds()),
+		Outcome:   outcome,
+	})
+}
+
+// summarizeScans totals up a session's scans.
+func summarizeScans(scans []structs.ScanStat) structs.Coverage {
+	c := structs.Coverage{}
+	adapters := make(map[string]bool)
+	for _, s := range scans {
+		c.Scans += 1
+		if s.Outcome != structs.ScanOK {
+			c.FailedScans += 1
+			continue
+		}
+		c.CaptureSeconds += s.Seconds
+		c.Frames += s.Frames
+		adapters[s.Adapter] = true
+		if c.FirstScan == 0 || s.Timestamp < c.FirstScan {
+			c.FirstScan = s.Timestamp
+		}
+		if s.Timestamp > c.LastScan {
+			c.LastScan = s.Timestamp
+		}
+	}
+	c.Adapters = len(adapters)
+	return c
+}
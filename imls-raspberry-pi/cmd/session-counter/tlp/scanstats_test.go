@@ -0,0 +1,104 @@
+package tlp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
+)
+
+func sessionScans(t *testing.T) []structs.ScanStat {
+	scans := []structs.ScanStat{}
+	err := state.GetDurationsDatabase().GetPtr().Select(&scans,
+		"SELECT * FROM scanstats WHERE session_id=? ORDER BY adapter", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scans
+}
+
+func clearScans(t *testing.T) {
+	if _, err := state.GetDurationsDatabase().GetPtr().Exec("DELETE FROM scanstats"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiSharkRecordsScans(t *testing.T) {
+	setup(t)
+	clearScans(t)
+	monitorFn := func(d *models.Device) error {
+		if d.Logicalname == "fakewan1" {
+			return errors.New("Cannot find device \"fakewan1\"")
+		}
+		return nil
+	}
+	slowShark := func(dev string) ([]string, error) {
+		state.GetClock().(*clock.Mock).Add(30 * time.Second)
+		return []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00", "DE:AD:BE:EF:00:00"}, nil
+	}
+	MultiShark(monitorFn, fakeDevicesFn, slowShark)
+
+	scans := sessionScans(t)
+	if len(scans) != 2 {
+		t.Fatal("expected a scan row per adapter, found ", scans)
+	}
+	ok, failed := scans[0], scans[1]
+	if ok.Adapter != "fakewan0" || ok.Outcome != structs.ScanOK ||
+		ok.Frames != 3 || ok.Devices != 2 || ok.Seconds != 30 {
+		t.Error("unexpected scan ", ok)
+	}
+	if failed.Adapter != "fakewan1" || failed.Outcome != FailureAdapter || failed.Frames != 0 {
+		t.Error("unexpected failed scan ", failed)
+	}
+
+	clearScans(t)
+	MultiShark(fakeMonitorFn, func() []*models.Device { return nil }, slowShark)
+	scans = sessionScans(t)
+	if len(scans) != 1 || scans[0].Adapter != "" || scans[0].Outcome != FailureAdapter {
+		t.Error("expected a failed scan when no adapter is found, found ", scans)
+	}
+}
+
+func TestSummarizeScans(t *testing.T) {
+	c := summarizeScans([]structs.ScanStat{
+		{Timestamp: 120, Adapter: "wlan0", Frames: 10, Seconds: 45, Outcome: structs.ScanOK},
+		{Timestamp: 60, Adapter: "wlan1", Frames: 5, Seconds: 45, Outcome: structs.ScanOK},
+		{Timestamp: 180, Adapter: "wlan0", Frames: 2, Seconds: 45, Outcome: structs.ScanOK},
+		{Timestamp: 240, Adapter: "wlan1", Outcome: FailureAdapter},
+	})
+	want := structs.Coverage{
+		Scans: 4, FailedScans: 1, CaptureSeconds: 135, Frames: 17,
+		Adapters: 2, FirstScan: 60, LastScan: 180,
+	}
+	if c != want {
+		t.Error("unexpected coverage ", c)
+	}
+}
+
+func TestProcessDataCoverage(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	MultiShark(fakeMonitorFn, fakeDevicesFn, fakeShark2)
+	db := state.GetDurationsDatabase()
+	ProcessData(db, state.NewQueue("sent"), state.NewQueue("images"))
+
+	coverage := []structs.Coverage{}
+	err := db.GetPtr().Select(&coverage,
+		"SELECT * FROM coverages WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 {
+		t.Fatal("expected one coverage summary for the session, found ", coverage)
+	}
+	c := coverage[0]
+	if c.Scans != 2 || c.FailedScans != 0 || c.Frames != 4 || c.Adapters != 2 ||
+		c.FirstScan != state.GetClock().Now().Unix() || c.DeviceTag != "testing" {
+		t.Error("unexpected coverage ", c)
+	}
+}
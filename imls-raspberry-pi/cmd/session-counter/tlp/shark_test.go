@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,13 +20,11 @@ import (
 // There's a lot of copypasta in these tests.
 
 func cleanupTempFiles() {
-	cfg := state.GetConfig()
-
-	f1, err := filepath.Glob(filepath.Join(cfg.GetWWWRoot(), "*.sqlite*"))
+	f1, err := filepath.Glob(filepath.Join(state.GetWWWRoot(), "*.sqlite*"))
 	if err != nil {
 		panic(err)
 	}
-	f2, err := filepath.Glob(filepath.Join(cfg.GetWWWImages(), "*.png"))
+	f2, err := filepath.Glob(filepath.Join(state.GetWWWImages(), "*.png"))
 	if err != nil {
 		panic(err)
 	}
@@ -35,52 +35,47 @@ func cleanupTempFiles() {
 		}
 	}
 }
-func setup() {
-	tempDB, err := os.CreateTemp("", "shark-test.sqlite")
-	if err != nil {
-		log.Fatal(err)
-	}
-	state.SetConfigAtPath(tempDB.Name())
-	cfg := state.GetConfig()
-	cfg.SetRunMode("test")
-	cfg.SetStorageMode("sqlite")
+func setup(t *testing.T) {
+	dir := t.TempDir()
+	state.SetConfigAtPath(filepath.Join(dir, "session-counter.ini"))
+	state.SetExclusionKeyPath(filepath.Join(dir, "exclude.key"))
+	state.SetRunMode("test")
+	state.SetStorageMode("sqlite")
 
 	_, filename, _, _ := runtime.Caller(0)
 	fmt.Println(filename)
 	path := filepath.Dir(filename)
-	cfg.SetQueuesPath(filepath.Join(path, "..", "test", "www", "queues.sqlite"))
-	cfg.SetDurationsPath(filepath.Join(path, "..", "test", "www", "durations.sqlite"))
-	cfg.SetRootPath(filepath.Join(path, "..", "test", "www"))
-	cfg.SetImagesPath(filepath.Join(path, "..", "test", "www", "images"))
-	cfg.SetFCFSSeqID("ME0000-001")
-	cfg.SetDeviceTag("testing")
-	cfg.Log().SetLogLevel("DEBUG")
+	state.SetQueuesPath(filepath.Join(path, "..", "test", "www", "queues.sqlite"))
+	state.SetDurationsPath(filepath.Join(path, "..", "test", "www", "durations.sqlite"))
+	state.SetRootPath(filepath.Join(path, "..", "test", "www"))
+	state.SetImagesPath(filepath.Join(path, "..", "test", "www", "images"))
+	state.SetFCFSSeqID("ME0000-001")
+	state.SetDeviceTag("testing")
 
 	state.FlushCache()
-	log.Println("Calling init config in setup")
-	log.Println("Trying to get session id")
-	log.Println("session id is ", cfg.GetCurrentSessionID())
-	cfg.Log().SetLogLevel("DEBUG")
+	state.ClearEphemeralDB()
 
-	os.Mkdir(cfg.GetWWWRoot(), 0755)
-	os.Mkdir(cfg.GetWWWImages(), 0755)
+	os.Mkdir(state.GetWWWRoot(), 0755)
+	os.Mkdir(state.GetWWWImages(), 0755)
 	mock := clock.NewMock()
 	mt, _ := time.Parse("2006-01-02T15:04", "1975-10-11T02:00")
 	mock.Set(mt)
 	state.SetClock(mock)
+	burstMu.Lock()
+	burstSupervisors = make(map[string]*Supervisor)
+	burstMu.Unlock()
 
 	if state.GetClock() == nil {
-		cfg.Log().Fatal("clock should not be nil")
+		log.Fatal("clock should not be nil")
 	}
-	cfg.Log().Debug("mock is now ", state.GetClock().Now().In(time.Local))
 }
 
 // type SharkFn func(string) []string
 // type MonitorFn func(*models.Device)
 // type SearchFn func() *models.Device
 
-func fakeMonitorFn(d *models.Device) {
-
+func fakeMonitorFn(d *models.Device) error {
+	return nil
 }
 
 func fakeSearchFn() (d *models.Device) {
@@ -88,22 +83,28 @@ func fakeSearchFn() (d *models.Device) {
 	return d
 }
 
-func fakeShark2(dev string) []string {
-	return []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00"}
+func fakeShark2(dev string) ([]string, error) {
+	return []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00"}, nil
 }
 
-func fakeShark1(dev string) []string {
-	return []string{"DE:AD:BE:EF:00:00"}
+func fakeShark1(dev string) ([]string, error) {
+	return []string{"DE:AD:BE:EF:00:00"}, nil
+}
+func checkMAC(t *testing.T, mac string, start time.Time, end time.Time) {
+	se, ok := state.GetMACs()[state.HashMAC(mac)]
+	if !ok {
+		t.Fatal("we did not get an entry for ", mac)
+	}
+	if !((se.Start == start.Unix()) && (se.End == end.Unix())) {
+		t.Error("things do not add up for ", mac)
+		t.Error(start.Unix(), se.Start, (se.Start == start.Unix()))
+		t.Error(end.Unix(), se.End, (se.End == end.Unix()))
+	}
 }
-func TestOneHour(t *testing.T) {
 
-	setup()
+func TestOneHour(t *testing.T) {
+	setup(t)
 	cleanupTempFiles()
-	cfg := state.GetConfig()
-
-	// Create a DB for simpleshark to write to.
-	db := state.NewSqliteDB(":memory:")
-	db.CreateTableFromStruct(structs.EphemeralDuration{})
 
 	startTime, _ := time.Parse(time.RFC3339, "1975-10-11T08:00:00-04:00")
 	endTime, _ := time.Parse(time.RFC3339, "1975-10-11T09:00:00-04:00")
@@ -113,42 +114,20 @@ func TestOneHour(t *testing.T) {
 	mock.Set(startTime)
 	state.SetClock(mock)
 	// Run once at the initial time.
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark2)
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark2)
 	mock.Set(endTime)
-	state.SetClock(mock)
 
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark2)
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark2)
 
-	// We should now be able to check the DB.
+	// We should now be able to check the ephemeral data.
 	for _, testmac := range []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00"} {
-		var ed structs.EphemeralDuration
-		row := db.GetPtr().QueryRowx("SELECT * FROM ephemeraldurations WHERE mac=?", testmac)
-		err := row.StructScan(&ed)
-		if err != nil {
-			cfg.Log().Error("We did not get a struct for ", testmac)
-			cfg.Log().Fatal(err)
-		} else {
-			if !((ed.MAC == testmac) && (ed.Start == startTime.Unix()) && (ed.End == endTime.Unix())) {
-				cfg.Log().Error("things do not add up for ", testmac)
-				cfg.Log().Error(ed.MAC, testmac, ed.MAC == testmac)
-				cfg.Log().Error(startTime.Unix(), ed.Start, (ed.Start == startTime.Unix()))
-				cfg.Log().Error(endTime.Unix(), ed.End, (ed.End == endTime.Unix()))
-				t.Fail()
-			}
-		}
+		checkMAC(t, testmac, startTime, endTime)
 	}
-
 }
 
 func TestOneYear(t *testing.T) {
-
-	setup()
+	setup(t)
 	cleanupTempFiles()
-	cfg := state.GetConfig()
-
-	// Create a DB for simpleshark to write to.
-	db := state.NewSqliteDB(":memory:")
-	db.CreateTableFromStruct(structs.EphemeralDuration{})
 
 	startTime, _ := time.Parse(time.RFC3339, "1975-10-11T08:00:00-04:00")
 	endTime, _ := time.Parse(time.RFC3339, "1976-10-11T09:00:00-04:00")
@@ -158,42 +137,24 @@ func TestOneYear(t *testing.T) {
 	mock.Set(startTime)
 	state.SetClock(mock)
 	// Run once at the initial time.
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark2)
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark2)
 	mock.Set(endTime)
-	state.SetClock(mock)
 
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark2)
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark2)
 
-	// We should now be able to check the DB.
+	// A year is well past the memory window, so both devices are "forgotten"
+	// and start over; the original sightings are kept under a hashed key.
 	for _, testmac := range []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00"} {
-		var ed structs.EphemeralDuration
-		row := db.GetPtr().QueryRowx("SELECT * FROM ephemeraldurations WHERE mac=?", testmac)
-		err := row.StructScan(&ed)
-		if err != nil {
-			cfg.Log().Error("We did not get a struct for ", testmac)
-			cfg.Log().Fatal(err)
-		} else {
-			if !((ed.MAC == testmac) && (ed.Start == startTime.Unix()) && (ed.End == endTime.Unix())) {
-				cfg.Log().Error("things do not add up for ", testmac)
-				cfg.Log().Error(ed.MAC, testmac, ed.MAC == testmac)
-				cfg.Log().Error(startTime.Unix(), ed.Start, (ed.Start == startTime.Unix()))
-				cfg.Log().Error(endTime.Unix(), ed.End, (ed.End == endTime.Unix()))
-				t.Fail()
-			}
-		}
+		checkMAC(t, testmac, endTime, endTime)
+	}
+	if len(state.GetMACs()) != 4 {
+		t.Error("expected 4 entries, found ", len(state.GetMACs()))
 	}
-
 }
 
 func TestBumpOne(t *testing.T) {
-
-	setup()
+	setup(t)
 	cleanupTempFiles()
-	cfg := state.GetConfig()
-
-	// Create a DB for simpleshark to write to.
-	db := state.NewSqliteDB(":memory:")
-	db.CreateTableFromStruct(structs.EphemeralDuration{})
 
 	startTime, _ := time.Parse(time.RFC3339, "1975-10-11T08:00:00-04:00")
 	endTime, _ := time.Parse(time.RFC3339, "1975-10-11T09:00:00-04:00")
@@ -203,47 +164,171 @@ func TestBumpOne(t *testing.T) {
 	mock.Set(startTime)
 	state.SetClock(mock)
 	// Run once at the initial time.
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark2)
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark2)
 	mock.Set(endTime)
-	state.SetClock(mock)
 
-	SimpleShark(db, fakeMonitorFn, fakeSearchFn, fakeShark1)
-
-	// We should now be able to check the DB.
-	for _, testmac := range []string{"DE:AD:BE:EF:00:00"} {
-		var ed structs.EphemeralDuration
-		row := db.GetPtr().QueryRowx("SELECT * FROM ephemeraldurations WHERE mac=?", testmac)
-		err := row.StructScan(&ed)
-		if err != nil {
-			cfg.Log().Error("We did not get a struct for ", testmac)
-			cfg.Log().Fatal(err)
-		} else {
-			if !((ed.MAC == testmac) && (ed.Start == startTime.Unix()) && (ed.End == endTime.Unix())) {
-				cfg.Log().Error("things do not add up for ", testmac)
-				cfg.Log().Error(ed.MAC, testmac, ed.MAC == testmac)
-				cfg.Log().Error(startTime.Unix(), ed.Start, (ed.Start == startTime.Unix()))
-				cfg.Log().Error(endTime.Unix(), ed.End, (ed.End == endTime.Unix()))
-				t.Fail()
-			}
+	SimpleShark(fakeMonitorFn, fakeSearchFn, fakeShark1)
+
+	checkMAC(t, "DE:AD:BE:EF:00:00", startTime, endTime)
+	// The second device was not seen again, so it should not have moved.
+	checkMAC(t, "BE:EF:00:00:00:00", startTime, startTime)
+}
+
+func TestStoreMacsSignalFloor(t *testing.T) {
+	setup(t)
+	state.SetSignalFloor(-75)
+	defer state.SetSignalFloor(0)
+
+	StoreMacs([]structs.Sighting{
+		ParseSighting("DE:AD:BE:EF:00:00\t2437\t-60"),
+		ParseSighting("BE:EF:00:00:00:00\t2437\t-90"),
+		ParseSighting("C0:FF:EE:00:00:00"),
+	})
+	macs := state.GetMACs()
+	if _, ok := macs[state.HashMAC("BE:EF:00:00:00:00")]; ok || len(macs) != 2 {
+		t.Error("the device in the parking lot should not be counted")
+	}
+	// We still know it was there.
+	if s, ok := state.GetSignal(state.HashMAC("BE:EF:00:00:00:00")); !ok || s.Max != -90 {
+		t.Error("the signal summary should include dropped sightings")
+	}
+}
+
+func fakeDevicesFn() []*models.Device {
+	return []*models.Device{
+		{Exists: true, Logicalname: "fakewan0"},
+		{Exists: true, Logicalname: "fakewan1"},
+		{Exists: false},
+	}
+}
+
+func TestMultiShark(t *testing.T) {
+	setup(t)
+	var mu sync.Mutex
+	monitored := make([]string, 0)
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	fakeShark := func(dev string) ([]string, error) {
+		started <- dev
+		// Both captures have to be running at once to get past here.
+		<-release
+		if dev == "fakewan0" {
+			return []string{"DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00"}, nil
 		}
+		return []string{"DE:AD:BE:EF:00:00", "C0:FF:EE:00:00:00", ""}, nil
 	}
+	go func() {
+		<-started
+		<-started
+		close(release)
+	}()
+
+	err := MultiShark(func(d *models.Device) error {
+		mu.Lock()
+		defer mu.Unlock()
+		monitored = append(monitored, d.Logicalname)
+		return nil
+	}, fakeDevicesFn, fakeShark)
+	if err != nil || len(monitored) != 2 {
+		t.Fatal("expected to capture on both adapters, monitored ", monitored)
+	}
+	if len(state.GetMACs()) != 3 {
+		t.Error("expected sightings to be merged into 3 devices, found ", len(state.GetMACs()))
+	}
+	stats := state.GetAdapterStats()
+	if stats["fakewan0"] != (state.AdapterStat{Sightings: 2, Devices: 2}) ||
+		stats["fakewan1"] != (state.AdapterStat{Sightings: 2, Devices: 2}) {
+		t.Error("unexpected adapter stats ", stats)
+	}
+}
+
+func TestMultiSharkNoDevices(t *testing.T) {
+	setup(t)
+	if MultiShark(fakeMonitorFn, func() []*models.Device { return nil }, fakeShark1) != ErrNoDevices {
+		t.Error("there is nothing to capture on")
+	}
+}
 
-	for _, testmac := range []string{"BE:EF:00:00:00:00"} {
-		var ed structs.EphemeralDuration
-		row := db.GetPtr().QueryRowx("SELECT * FROM ephemeraldurations WHERE mac=?", testmac)
-		err := row.StructScan(&ed)
-		if err != nil {
-			cfg.Log().Error("We did not get a struct for ", testmac)
-			cfg.Log().Fatal(err)
-		} else {
-			if (ed.MAC == testmac) && (ed.Start == startTime.Unix()) && (ed.End == endTime.Unix()) {
-				cfg.Log().Error("things DO add up for ", testmac)
-				cfg.Log().Error(ed.MAC, testmac, ed.MAC == testmac)
-				cfg.Log().Error(startTime.Unix(), ed.Start, (ed.Start == startTime.Unix()))
-				cfg.Log().Error(endTime.Unix(), ed.End, (ed.End == endTime.Unix()))
-				t.Fail()
+func TestResetDuringCapture(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				MultiShark(fakeMonitorFn, fakeDevicesFn, fakeShark2)
 			}
 		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		state.PauseCapture()
+		// Both adapters see both devices, and store them together: a
+		// session has all of a capture or none of it.
+		if n := len(state.GetMACs()); n != 0 && n != 2 {
+			t.Error("a reset landed partway through storing a capture, found ", n)
+		}
+		state.IncrementSessionID()
+		state.ClearEphemeralDB()
+		state.ResumeCapture()
+		time.Sleep(time.Millisecond)
 	}
+	close(stop)
+	<-done
+}
 
+func TestOverlappingCaptures(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+	// A burst capture that overruns its minute runs alongside the next
+	// one, and a reset can land in the middle of both. The first round
+	// on both adapters of both captures finishes together.
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			<-started
+		}
+		close(release)
+	}()
+	var calls int32
+	shark := func(dev string) ([]string, error) {
+		if atomic.AddInt32(&calls, 1) <= 4 {
+			started <- struct{}{}
+			<-release
+		}
+		return fakeShark2(dev)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				MultiShark(fakeMonitorFn, fakeDevicesFn, shark)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		state.PauseCapture()
+		state.GetAdapterStats()
+		state.GetChannelStats()
+		state.IncrementSessionID()
+		state.ClearEphemeralDB()
+		state.ResumeCapture()
+	}
+	wg.Wait()
+
+	scans := []structs.ScanStat{}
+	if err := state.GetDurationsDatabase().GetPtr().Select(&scans, "SELECT * FROM scanstats"); err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 2*2*10 {
+		t.Error("expected every scan on every adapter to be recorded, found ", len(scans))
+	}
 }
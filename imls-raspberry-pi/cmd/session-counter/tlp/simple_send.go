@@ -8,58 +8,141 @@ import (
 	"gsa.gov/18f/internal/structs"
 )
 
-func SimpleSend(db interfaces.Database) {
-	log.Debug().
-		Msg("starting batch send")
+// sessionRows is what gets uploaded for a session: the durations, or
+// under `privacy.mode` dp only the noisy aggregates, and the coverage.
+type sessionRows struct {
+	uri      string
+	kind     string
+	data     []map[string]interface{}
+	coverage []map[string]interface{}
+}
 
-	// This only comes in on reset...
-	sq := state.NewQueue("sent")
-	sessionsToSend := sq.AsList()
+// uploadKind is what a session's data goes up as: durations, or under
+// `privacy.mode` dp aggregates.
+func uploadKind() string {
+	if state.GetPrivacyMode() == "dp" {
+		return structs.UploadAggregates
+	}
+	return structs.UploadDurations
+}
 
-	for _, nextSessionIDToSend := range sessionsToSend {
+func selectSession(db interfaces.Database, session string) sessionRows {
+	rows := sessionRows{
+		uri:      state.GetDurationsURI(),
+		kind:     uploadKind(),
+		data:     make([]map[string]interface{}, 0),
+		coverage: make([]map[string]interface{}, 0),
+	}
+
+	if rows.kind == structs.UploadAggregates {
+		rows.uri = state.GetAggregatesURI()
+		aggregates := []structs.Aggregate{}
+		// FIXME: Leaky Abstraction
+		err := db.GetPtr().Select(&aggregates, "SELECT * FROM aggregates WHERE session_id=?", session)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("session", session).
+				Msg("could not extract aggregates")
+		}
+		for _, a := range aggregates {
+			rows.data = append(rows.data, a.AsMap())
+		}
+	} else {
 		durations := []structs.Duration{}
 		// FIXME: Leaky Abstraction
-		err := db.GetPtr().Select(&durations, "SELECT * FROM durations WHERE session_id=?", nextSessionIDToSend)
-
+		err := db.GetPtr().Select(&durations, "SELECT * FROM durations WHERE session_id=?", session)
 		if err != nil {
 			log.Error().
 				Err(err).
-				Str("session", nextSessionIDToSend).
+				Str("session", session).
 				Msg("could not extract durations")
 		}
+		// convert []Duration to an array of map[string]interface{}
+		for _, duration := range durations {
+			rows.data = append(rows.data, duration.AsMap())
+		}
+	}
+
+	coverage := []structs.Coverage{}
+	err := db.GetPtr().Select(&coverage, "SELECT * FROM coverages WHERE session_id=?", session)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("session", session).
+			Msg("could not extract coverage")
+	}
+	for _, c := range coverage {
+		rows.coverage = append(rows.coverage, c.AsMap())
+	}
+	return rows
+}
+
+func SimpleSend(db interfaces.Database) {
+	log.Debug().
+		Msg("starting batch send")
+
+	// This only comes in on reset...
+	sq := state.NewQueue("sent")
+	sessionsToSend := sq.AsList()
+	// The sessions whose durations (or aggregates) are up, but whose
+	// coverage is not yet. They are not sent again.
+	dq := state.NewQueue("sent_data")
+	dataSent := make(map[string]bool)
+	for _, session := range dq.AsList() {
+		dataSent[session] = true
+	}
 
-		if len(durations) == 0 {
+	for _, nextSessionIDToSend := range sessionsToSend {
+		rows := selectSession(db, nextSessionIDToSend)
+
+		if len(rows.data) == 0 && len(rows.coverage) == 0 {
 			log.Debug().
 				Str("session", nextSessionIDToSend).
 				Msg("found zero durations")
 			sq.Remove(nextSessionIDToSend)
 		} else if state.IsStoringToAPI() {
-			log.Debug().
-				Int("durations", len(durations)).
-				Str("session", nextSessionIDToSend).
-				Msg("preparing to send durations to API")
+			// After writing images, we come back and try and send the data remotely.
+			sent := true
+			if len(rows.data) > 0 && !dataSent[nextSessionIDToSend] {
+				log.Debug().
+					Int("rows", len(rows.data)).
+					Str("session", nextSessionIDToSend).
+					Str("uri", rows.uri).
+					Msg("sending session to API")
 
-			// convert []Duration to an array of map[string]interface{}
-			data := make([]map[string]interface{}, 0)
-			for _, duration := range durations {
-				data = append(data, duration.AsMap())
+				err := http.PostJSON(rows.uri, rows.data)
+				if err != nil {
+					log.Error().
+						Str("session", nextSessionIDToSend).
+						Err(err).
+						Msg("could not send; data left on queue")
+					sent = false
+				} else {
+					dq.Push(nextSessionIDToSend)
+					recordUpload(db, nextSessionIDToSend, rows.kind)
+				}
 			}
 
-			// After writing images, we come back and try and send the data remotely.
-			log.Debug().
-				Int("duration", len(data)).
-				Str("session", nextSessionIDToSend).
-				Msg("sending durations to API")
+			// The coverage goes even on a day with no durations; that is
+			// when it matters most.
+			if sent && len(rows.coverage) > 0 {
+				err := http.PostJSON(state.GetCoverageURI(), rows.coverage)
+				if err != nil {
+					log.Error().
+						Str("session", nextSessionIDToSend).
+						Err(err).
+						Msg("could not send coverage; data left on queue")
+					sent = false
+				} else {
+					recordUpload(db, nextSessionIDToSend, structs.UploadCoverage)
+				}
+			}
 
-			err = http.PostJSON(state.GetDurationsURI(), data)
-			if err != nil {
-				log.Error().
-					Str("session", nextSessionIDToSend).
-					Err(err).
-					Msg("could not send; data left on queue")
-			} else {
+			if sent {
 				// If we successfully sent the data remotely, we can now mark it is as sent.
 				sq.Remove(nextSessionIDToSend)
+				dq.Remove(nextSessionIDToSend)
 			}
 		} else {
 			// Always dequeue. We're storing locally "for free" into the
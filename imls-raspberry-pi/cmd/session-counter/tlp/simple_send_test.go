@@ -0,0 +1,62 @@
+package tlp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestSimpleSendRetriesOnlyWhatFailed(t *testing.T) {
+	setup(t)
+	cleanupTempFiles()
+
+	var mu sync.Mutex
+	posts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		kind := "durations"
+		if strings.Contains(r.URL.Path, "coverage") {
+			kind = "coverage"
+		}
+		posts[kind] += 1
+		// The coverage fails the first time.
+		if kind == "coverage" && posts[kind] == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	scheme, host := viper.GetString("api.scheme"), viper.GetString("api.host")
+	viper.Set("api.scheme", "http")
+	viper.Set("api.host", srv.Listener.Addr().String())
+	defer viper.Set("api.scheme", scheme)
+	defer viper.Set("api.host", host)
+	state.SetStorageMode("api")
+	defer state.SetStorageMode("sqlite")
+
+	db := state.GetDurationsDatabase()
+	db.GetTableFromStruct(structs.Duration{}).InsertStruct(structs.Duration{SessionID: "42", Start: 1, End: 2})
+	db.GetTableFromStruct(structs.Coverage{}).InsertStruct(structs.Coverage{SessionID: "42", Scans: 1})
+	sq := state.NewQueue("sent")
+	sq.Push("42")
+
+	SimpleSend(db)
+	if len(sq.AsList()) != 1 {
+		t.Fatal("the session should stay queued until its coverage is sent")
+	}
+	SimpleSend(db)
+	if len(sq.AsList()) != 0 {
+		t.Error("the session should be dequeued once everything is sent")
+	}
+	if posts["durations"] != 1 || posts["coverage"] != 2 {
+		t.Error("only the coverage should have been sent again, got ", posts)
+	}
+}
@@ -4,45 +4,132 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"gsa.gov/18f/cmd/session-counter/constants"
+	"gsa.gov/18f/internal/capture"
 	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
 	"gsa.gov/18f/internal/wifi-hardware-search/models"
 )
 
-func TSharkRunner(adapter string) []string {
+// The fields we ask tshark for, in order. tshark separates them with
+// tabs; the native backend writes its lines the same way.
+var SharkFields = []string{
+	"wlan.sa",
+	"radiotap.channel.freq",
+	"radiotap.dbm_antsignal",
+	"wlan.fc.type_subtype",
+	"wlan.fc.ds",
+	// Fingerprint material; only filled in for probe requests.
+	"wlan.tag.number",
+	"wlan.supported_rates",
+	"wlan.extended_supported_rates",
+	"wlan.ht.capabilities",
+	"wlan.vht.capabilities",
+	"wlan.tag.oui",
+	// Which network the frame belongs to, and its name for beacons and
+	// probe responses.
+	"wlan.bssid",
+	"wlan.ssid",
+}
+
+// Where things sit in SharkFields.
+const (
+	fingerprintFrom = 5
+	fingerprintTo   = 11
+	bssidField      = 11
+	ssidField       = 12
+)
+
+func tsharkFieldArgs() []string {
+	args := []string{"-Tfields"}
+	for _, f := range SharkFields {
+		args = append(args, "-e", f)
+	}
+	return args
+}
+
+// firstValue trims a tshark field down to its first value; fields that
+// occur more than once in a frame are printed comma-separated.
+func firstValue(field string) string {
+	return strings.TrimSpace(strings.Split(field, ",")[0])
+}
+
+// ParseSighting splits one line of SharkFn output into its SharkFields.
+// Missing or unparseable trailing fields are left at their zero values,
+// so a bare MAC address is a valid line.
+func ParseSighting(line string) structs.Sighting {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	s := structs.Sighting{MAC: firstValue(fields[0])}
+	s.Randomized = structs.IsRandomized(s.MAC)
+	if len(fields) > 1 {
+		freq, _ := strconv.Atoi(firstValue(fields[1]))
+		s.Channel = capture.Channel(freq)
+	}
+	if len(fields) > 2 {
+		// With more than one antenna tshark lists each; the first is
+		// the combined signal.
+		s.Signal, _ = strconv.Atoi(firstValue(fields[2]))
+	}
+	typeSubtype := int64(-1)
+	if len(fields) > 3 {
+		// Depending on the version, tshark prints these in hex or decimal.
+		var err error
+		typeSubtype, err = strconv.ParseInt(firstValue(fields[3]), 0, 32)
+		if err == nil {
+			var ds int64
+			if len(fields) > 4 {
+				ds, _ = strconv.ParseInt(firstValue(fields[4]), 0, 32)
+			}
+			s.Class = frameClass(int(typeSubtype), int(ds))
+		}
+	}
+	if len(fields) > fingerprintFrom && s.Class == structs.FrameProbe && state.GetFingerprinting() {
+		to := fingerprintTo
+		if len(fields) < to {
+			to = len(fields)
+		}
+		// Hashed straight away; the material itself goes no further.
+		if material := strings.Join(fields[fingerprintFrom:to], "\t"); strings.TrimSpace(material) != "" {
+			s.Fingerprint = state.Fingerprint(material)
+		}
+	}
+	if len(fields) > bssidField {
+		s.BSSID = strings.ToLower(firstValue(fields[bssidField]))
+	}
+	// SSIDs can have commas in them, so this one is taken whole.
+	if len(fields) > ssidField && advertisesNetwork(int(typeSubtype)) {
+		s.SSID = fields[ssidField]
+	}
+	return s
+}
+
+// TSharkRunner captures on an adapter for `wireshark.duration`. If tshark
+// cannot be started, or exits badly, the error says why; what tshark
+// wrote to stderr is included, since that is where it explains itself.
+func TSharkRunner(adapter string) ([]string, error) {
 	tsharkCmd := exec.Command(
 		state.GetWiresharkPath(),
-		"-a", fmt.Sprintf("duration:%d", state.GetWiresharkDuration()),
-		"-I", "-i", adapter,
-		"-Tfields", "-e", "wlan.sa")
+		append([]string{
+			"-a", fmt.Sprintf("duration:%d", state.GetWiresharkDuration()),
+			"-I", "-i", adapter},
+			append(captureFilterArgs(), tsharkFieldArgs()...)...)...)
 
 	tsharkOut, err := tsharkCmd.StdoutPipe()
 	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("could not open wireshark pipe")
-	}
-	tsharkErr, err2 := tsharkCmd.StderrPipe()
-	if err2 != nil {
-		log.Error().
-			Err(err2).
-			Msg("could not open wireshark stderr pipe")
+		return nil, fmt.Errorf("could not open wireshark pipe: %w", err)
 	}
+	tsharkErr := &tailBuffer{max: 4096}
+	tsharkCmd.Stderr = tsharkErr
 
-	// The closer is called on exe exit. Idomatic use does not
-	// explicitly call the closer. BUT DO WE HAVE LEAKS?
-	defer tsharkOut.Close()
-	defer tsharkErr.Close()
-
-	err = tsharkCmd.Start()
-	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("could not execute wireshark")
+	if err := tsharkCmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not execute wireshark: %w", err)
 	}
 	tsharkBytes, err := ioutil.ReadAll(tsharkOut)
 	if err != nil {
@@ -50,83 +137,209 @@ func TSharkRunner(adapter string) []string {
 			Err(err).
 			Msg("could not read from wireshark output")
 	}
-	tsharkErrBytes, err2 := ioutil.ReadAll(tsharkErr)
-	if err2 != nil {
-		log.Error().
-			Err(err2).
-			Msg("could not read from wireshark stderr")
-	}
 
-	//tsharkCmd.Wait()
 	// From https://stackoverflow.com/questions/10385551/get-exit-code-go
 	if err := tsharkCmd.Wait(); err != nil {
 		if exiterr, ok := err.(*exec.ExitError); ok {
 			// The program has exited with an exit code != 0
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-				log.Fatal().
+				log.Error().
 					Int("exit status", status.ExitStatus()).
 					Str("tshark command", tsharkCmd.String()).
-					Str("stderr", string(tsharkErrBytes)).
-					Str("stdout", string(tsharkBytes)).
+					Str("stderr", tsharkErr.String()).
 					Msg("tshark exited unexpectedly")
 			}
-		} else {
-			log.Fatal().
-				Err(err).
-				Msg("tshark did not wait")
 		}
+		return nil, fmt.Errorf("tshark exited: %w: %s", err, tsharkErr.String())
 	}
 
 	macs := strings.Split(string(tsharkBytes), "\n")
 
-	return macs
+	return macs, nil
 }
 
-type SharkFn func(string) []string
-type MonitorFn func(*models.Device)
+type SharkFn func(string) ([]string, error)
+type MonitorFn func(*models.Device) error
 type SearchFn func() *models.Device
+type DevicesFn func() []*models.Device
+
+// singleDevice turns a SearchFn into a DevicesFn.
+func singleDevice(searchFn SearchFn) DevicesFn {
+	return func() []*models.Device {
+		return []*models.Device{searchFn()}
+	}
+}
+
+// existingDevices drops the devices a search came back without.
+func existingDevices(devices []*models.Device) []*models.Device {
+	found := make([]*models.Device, 0)
+	for _, dev := range devices {
+		if dev != nil && dev.Exists {
+			found = append(found, dev)
+		}
+	}
+	return found
+}
 
 func SimpleShark(
 	setMonitorFn MonitorFn,
 	searchFn SearchFn,
-	sharkFn SharkFn) bool {
+	sharkFn SharkFn) error {
+	return MultiShark(setMonitorFn, singleDevice(searchFn), sharkFn)
+}
+
+// How long a burst capture waits before trying a failed adapter again,
+// at first. Bursts are once a minute.
+const BurstRetryDelay = 1 * time.Minute
+
+// burstSupervisors keeps a Supervisor per adapter between bursts, and
+// one under "" for finding an adapter at all. burstMu guards the map.
+var burstSupervisors = make(map[string]*Supervisor)
+var burstMu sync.Mutex
 
-	// Look up the adapter. Use the find-ralink library.
-	// The % will trigger first time through, which we want.
-	var dev *models.Device = nil
-	// If the config doesn't have this in it, we get a divide by zero.
-	dev = searchFn()
+func burstSupervisor(adapter string) *Supervisor {
+	burstMu.Lock()
+	defer burstMu.Unlock()
+	sv, ok := burstSupervisors[adapter]
+	if !ok {
+		sv = NewSupervisor(adapter, BurstRetryDelay)
+		burstSupervisors[adapter] = sv
+	}
+	return sv
+}
+
+// MultiShark is SimpleShark for every adapter devicesFn finds. The
+// captures run side by side, and their sightings are stored together
+// once they have all finished. Each adapter has its own Supervisor, as
+// in MultiStreamShark: one that fails is logged, marked unhealthy, and
+// left out of the bursts until its backoff runs out, while the others
+// carry on. MultiShark only returns an error if none of the adapters it
+// tried could capture.
+func MultiShark(
+	setMonitorFn MonitorFn,
+	devicesFn DevicesFn,
+	sharkFn SharkFn) error {
 
 	// Only do a reading and continue the pipeline
 	// if we find an adapter.
-	if dev != nil && dev.Exists {
-		// Load the config for use.
-		// cfg.Wireshark.Adapter = dev.Logicalname
-		setMonitorFn(dev)
-		// This blocks for monitoring...
-		macmap := sharkFn(dev.Logicalname)
-		// Mark and remove too-short MAC addresses
-		// for removal from the tshark findings.
-		var keepers []string
-		for _, mac := range macmap {
-			if len(mac) >= constants.MACLENGTH {
-				keepers = append(keepers, mac)
-			}
+	discovery := burstSupervisor("")
+	found := existingDevices(devicesFn())
+	if len(found) == 0 {
+		if !discovery.Ready() {
+			return ErrNoDevices
 		}
-		StoreMacs(keepers)
-	} else {
 		log.Info().
 			Msg("no wifi devices found; no scanning carried out")
-		return false
+		state.StartCapture()
+		defer state.EndCapture()
+		recordScan("", state.GetClock().Now(), nil, ClassifyFailure(ErrNoDevices))
+		discovery.Failed(ErrNoDevices)
+		return ErrNoDevices
+	}
+	if discovery.failing() {
+		discovery.Succeeded()
+	}
+
+	// Leave out the adapters still backing off.
+	devices := make([]*models.Device, 0)
+	for _, dev := range found {
+		if burstSupervisor(dev.Logicalname).Ready() {
+			devices = append(devices, dev)
+		}
 	}
-	return true
+	if len(devices) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(devices))
+	for i, dev := range devices {
+		errs[i] = setMonitor(setMonitorFn, dev)
+	}
+	// This blocks for monitoring...
+	started := state.GetClock().Now()
+	results := make([][]string, len(devices))
+	var wg sync.WaitGroup
+	for i, dev := range devices {
+		if errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, adapter string) {
+			defer wg.Done()
+			results[i], errs[i] = sharkFn(adapter)
+		}(i, dev.Logicalname)
+	}
+	wg.Wait()
+
+	// What was seen goes into one session or the other, not both.
+	state.StartCapture()
+	defer state.EndCapture()
+	// Mark and remove too-short MAC addresses
+	// for removal from the tshark findings.
+	var keepers []structs.Sighting
+	var failed error
+	for i, lines := range results {
+		adapter := devices[i].Logicalname
+		sv := burstSupervisor(adapter)
+		if errs[i] != nil {
+			recordScan(adapter, started, nil, ClassifyFailure(errs[i]))
+			failed = fmt.Errorf("%s: %w", adapter, errs[i])
+			sv.Failed(errs[i])
+			continue
+		}
+		sv.Succeeded()
+		scanned := make([]structs.Sighting, 0)
+		for _, line := range lines {
+			s := ParseSighting(line)
+			if isMAC(s.MAC) {
+				s.Adapter = adapter
+				scanned = append(scanned, s)
+			}
+		}
+		recordScan(adapter, started, scanned, structs.ScanOK)
+		keepers = append(keepers, scanned...)
+	}
+	StoreMacs(keepers)
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return failed
 }
 
-func StoreMacs(keepers []string) {
+// isMAC filters out the blank and truncated lines tshark hands back.
+func isMAC(mac string) bool {
+	return len(mac) >= constants.MACLENGTH
+}
+
+func StoreMacs(keepers []structs.Sighting) {
 	//cfg := state.GetConfig()
 	// Do not log MAC addresses...
 	//cfg.Log().Debug("found ", len(keepers), " keepers")
-	for _, mac := range keepers {
-		state.RecordMAC(mac)
+	for _, s := range keepers {
+		if s.SSID != "" {
+			state.RecordBeacon(s.SSID, s.BSSID)
+		}
+		if !wantFrame(s.Class) || state.IsExcluded(s.MAC) {
+			continue
+		}
+		// The last look at the raw MAC.
+		s.Manufacturer = manufacturerTable().Category(s.MAC)
+		s = state.GroupSighting(state.HashSighting(s))
+		if s.Channel != 0 {
+			state.RecordChannel(s.Channel, s.MAC)
+		}
+		if s.Adapter != "" {
+			state.RecordAdapter(s.Adapter, s.MAC)
+		}
+		if s.Signal != 0 {
+			state.RecordSignal(s.MAC, s.Signal)
+		}
+		if !state.SignalCounts(s.MAC, s.Signal) {
+			continue
+		}
+		state.RecordSighting(s)
 	}
 }
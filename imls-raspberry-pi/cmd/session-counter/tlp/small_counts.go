@@ -0,0 +1,120 @@
+package tlp
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// smallCounts is what protectSmallCounts held back from a session.
+type smallCounts struct {
+	// Durations dropped for being in too small a bucket.
+	suppressed int
+	// Hourly buckets folded into a neighbour.
+	merged int
+}
+
+// A bucket is the local hours a visit started and ended in.
+type bucket struct {
+	start, end int64
+}
+
+// protectSmallCounts makes sure no bucket of visits holds fewer than
+// `privacy.k` devices, so one early bird (or late leaver) cannot be
+// picked out by when they came and went. Durations are bucketed by the
+// local hours they start and end in. Under "suppress", small buckets are
+// dropped. Under "merge", each small bucket is folded into the next (or,
+// at the end of the day, the previous) until every group holds k; every
+// duration in a merged group is reported as the whole group, from the
+// hour the first of them started to the end of the hour the last of
+// them left. If the whole day holds fewer than k devices, nothing is
+// reported.
+func protectSmallCounts(durations []structs.Duration) ([]structs.Duration, smallCounts) {
+	k := state.GetPrivacyK()
+	held := smallCounts{}
+	if k <= 1 || len(durations) == 0 {
+		return durations, held
+	}
+
+	buckets := make(map[bucket][]structs.Duration)
+	for _, d := range durations {
+		b := bucket{localHour(time.Unix(d.Start, 0)).Unix(), localHour(time.Unix(d.End, 0)).Unix()}
+		buckets[b] = append(buckets[b], d)
+	}
+	hours := make([]bucket, 0)
+	for b := range buckets {
+		hours = append(hours, b)
+	}
+	sort.Slice(hours, func(i, j int) bool {
+		if hours[i].start != hours[j].start {
+			return hours[i].start < hours[j].start
+		}
+		return hours[i].end < hours[j].end
+	})
+
+	// Runs of consecutive buckets, each to be reported as one.
+	groups := make([][]bucket, 0)
+	if state.GetPrivacySmallBuckets() == "suppress" {
+		for _, hour := range hours {
+			if len(buckets[hour]) < k {
+				held.suppressed += len(buckets[hour])
+			} else {
+				groups = append(groups, []bucket{hour})
+			}
+		}
+	} else {
+		group, count := make([]bucket, 0), 0
+		for _, hour := range hours {
+			group = append(group, hour)
+			count += len(buckets[hour])
+			if count >= k {
+				groups = append(groups, group)
+				group, count = make([]bucket, 0), 0
+			}
+		}
+		if len(group) > 0 {
+			if len(groups) > 0 {
+				groups[len(groups)-1] = append(groups[len(groups)-1], group...)
+			} else {
+				held.suppressed += count
+			}
+		}
+	}
+
+	kept := make([]structs.Duration, 0)
+	for _, group := range groups {
+		held.merged += len(group) - 1
+		// Sorted, so the group starts with its first bucket.
+		start, end := group[0].start, group[0].end
+		for _, b := range group {
+			if b.end > end {
+				end = b.end
+			}
+		}
+		end = time.Unix(end, 0).Add(time.Hour).Unix()
+		for _, b := range group {
+			for _, d := range buckets[b] {
+				if len(group) > 1 {
+					d.Start, d.End = start, end
+				}
+				kept = append(kept, d)
+			}
+		}
+	}
+	// Keep the patron indexes dense, so they do not count what was
+	// dropped.
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].PatronID < kept[j].PatronID })
+	for ndx := range kept {
+		kept[ndx].PatronID = ndx
+	}
+
+	log.Info().
+		Int("k", k).
+		Int("suppressed", held.suppressed).
+		Int("merged", held.merged).
+		Msg("protected small counts")
+	return kept, held
+}
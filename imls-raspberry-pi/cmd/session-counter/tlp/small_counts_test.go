@@ -0,0 +1,118 @@
+package tlp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// arrivals makes durations starting at the given minutes past 06:00, each
+// lasting ten minutes.
+func arrivals(minutes ...int) []structs.Duration {
+	six := time.Date(1975, 10, 11, 6, 0, 0, 0, time.Local)
+	durations := make([]structs.Duration, 0)
+	for ndx, m := range minutes {
+		start := six.Add(time.Duration(m) * time.Minute).Unix()
+		durations = append(durations, structs.Duration{PatronID: ndx, Start: start, End: start + 600})
+	}
+	return durations
+}
+
+func TestSmallCountsOff(t *testing.T) {
+	setup(t)
+	durations := arrivals(5, 70, 71)
+	kept, held := protectSmallCounts(durations)
+	if len(kept) != 3 || held != (smallCounts{}) || kept[0].Start != durations[0].Start {
+		t.Error("expected nothing to change with privacy.k off ", kept, held)
+	}
+}
+
+func TestSmallCountsSuppress(t *testing.T) {
+	setup(t)
+	state.SetPrivacyK(2)
+	state.SetPrivacySmallBuckets("suppress")
+	defer state.SetPrivacyK(0)
+	defer state.SetPrivacySmallBuckets("merge")
+
+	// One device at 06:05; two in the seven o'clock hour.
+	durations := arrivals(5, 70, 71)
+	kept, held := protectSmallCounts(durations)
+	if len(kept) != 2 || held.suppressed != 1 || held.merged != 0 {
+		t.Fatal("expected the early bird to be dropped ", kept, held)
+	}
+	if kept[0].PatronID != 0 || kept[1].PatronID != 1 || kept[0].Start != durations[1].Start {
+		t.Error("expected the rest untouched and renumbered ", kept)
+	}
+}
+
+func TestSmallCountsMerge(t *testing.T) {
+	setup(t)
+	state.SetPrivacyK(2)
+	defer state.SetPrivacyK(0)
+
+	// 06:05 is folded into seven o'clock; 09:00 is alone at the end of
+	// the day and folds back into the same group.
+	durations := arrivals(5, 70, 180)
+	kept, held := protectSmallCounts(durations)
+	if len(kept) != 3 || held.suppressed != 0 || held.merged != 2 {
+		t.Fatal("expected the three hours to be merged into one ", kept, held)
+	}
+	six := durations[0].Start - 5*60
+	for _, d := range kept {
+		if d.Start != six || d.End != six+4*3600 {
+			t.Error("expected every merged duration to run from 06:00 to 10:00 ", d)
+		}
+	}
+
+	// A whole day with fewer than k devices reports nothing.
+	state.SetPrivacyK(4)
+	if kept, held = protectSmallCounts(durations); len(kept) != 0 || held.suppressed != 3 {
+		t.Error("expected the whole day to be suppressed ", kept, held)
+	}
+}
+
+func TestSmallCountsEndTimes(t *testing.T) {
+	setup(t)
+	state.SetPrivacyK(2)
+	defer state.SetPrivacyK(0)
+
+	// Two devices arrive in the same hour, but one of them stays until
+	// the afternoon; when it left would single it out.
+	durations := arrivals(5, 10)
+	durations[1].End += 6 * 3600
+	kept, held := protectSmallCounts(durations)
+	if len(kept) != 2 || held.merged != 1 {
+		t.Fatal("expected the two buckets to be merged ", kept, held)
+	}
+	if kept[0].Start != kept[1].Start || kept[0].End != kept[1].End {
+		t.Error("expected the merged durations to be reported alike ", kept)
+	}
+
+	state.SetPrivacySmallBuckets("suppress")
+	defer state.SetPrivacySmallBuckets("merge")
+	if kept, held = protectSmallCounts(durations); len(kept) != 0 || held.suppressed != 2 {
+		t.Error("expected both to be suppressed ", kept, held)
+	}
+}
+
+func TestProcessDataRecordsSmallCounts(t *testing.T) {
+	setup(t)
+	state.SetPrivacyK(100)
+	defer state.SetPrivacyK(0)
+
+	if durations := processFixture(t); len(durations) != 0 {
+		t.Error("expected every duration to be held back, found ", len(durations))
+	}
+	coverage := []structs.Coverage{}
+	err := state.GetDurationsDatabase().GetPtr().Select(&coverage,
+		"SELECT * FROM coverages WHERE session_id=?", fmt.Sprint(state.GetCurrentSessionID()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coverage) != 1 || coverage[0].KThreshold != 100 || coverage[0].SuppressedDurations != 6 {
+		t.Error("expected the suppression in the session's coverage ", coverage)
+	}
+}
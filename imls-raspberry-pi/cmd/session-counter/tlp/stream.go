@@ -0,0 +1,369 @@
+package tlp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/capture"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// How often streamed sightings are handed to RecordMAC.
+const StreamBatchInterval = 1 * time.Minute
+
+// How long to wait before restarting a capture that exited, or before
+// looking for an adapter again when none was found.
+const StreamRestartDelay = 10 * time.Second
+
+// A StreamFn captures on an adapter until the capture dies or `stop` is
+// closed, writing a line per frame to `out` in the same form a SharkFn
+// returns them.
+type StreamFn func(adapter string, out chan<- string, stop <-chan struct{}) error
+
+// tailBuffer keeps the last few KB written to it. A long-running tshark
+// can write to stderr for days; we only want the end when it exits.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (tb *tailBuffer) Write(p []byte) (int, error) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.buf = append(tb.buf, p...)
+	if len(tb.buf) > tb.max {
+		tb.buf = tb.buf[len(tb.buf)-tb.max:]
+	}
+	return len(p), nil
+}
+
+func (tb *tailBuffer) String() string {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return string(tb.buf)
+}
+
+// TSharkStreamer runs tshark without an autostop condition, line-buffered,
+// and streams SharkFields until tshark exits or we are told to stop.
+func TSharkStreamer(adapter string, out chan<- string, stop <-chan struct{}) error {
+	tsharkCmd := exec.Command(
+		state.GetWiresharkPath(),
+		append([]string{"-l", "-I", "-i", adapter},
+			append(captureFilterArgs(), tsharkFieldArgs()...)...)...)
+
+	tsharkOut, err := tsharkCmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	tsharkErr := &tailBuffer{max: 4096}
+	tsharkCmd.Stderr = tsharkErr
+
+	if err := tsharkCmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-stop:
+			tsharkCmd.Process.Kill()
+		case <-exited:
+		}
+	}()
+
+	scanner := bufio.NewScanner(tsharkOut)
+	for scanner.Scan() {
+		select {
+		case out <- scanner.Text():
+		case <-stop:
+		}
+	}
+
+	if err := tsharkCmd.Wait(); err != nil {
+		select {
+		case <-stop:
+			// We killed it.
+			return nil
+		default:
+		}
+		return fmt.Errorf("tshark exited: %w: %s", err, tsharkErr.String())
+	}
+	return nil
+}
+
+// NativeStreamer is the StreamFn for the native capture backend.
+func NativeStreamer(adapter string, out chan<- string, stop <-chan struct{}) error {
+	src, err := capture.OpenLive(adapter, 0)
+	if err != nil {
+		return err
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+		case <-finished:
+		}
+		src.Close()
+	}()
+
+	for {
+		p, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, ok := nativeLine(p)
+		if !ok {
+			continue
+		}
+		select {
+		case out <- line:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// GetStreamFn returns the StreamFn for `capture.backend`.
+func GetStreamFn() StreamFn {
+	if state.GetCaptureBackend() == "native" {
+		return NativeStreamer
+	}
+	return TSharkStreamer
+}
+
+// batchMACs collects streamed sightings and stores them once per
+// StreamBatchInterval, so RecordMAC sees the same once-a-minute clock
+// it does in burst mode. Whatever is pending is stored on stop. Each
+// batch is a scan for every adapter that was capturing through it, so
+// a quiet adapter still shows as scanning.
+func batchMACs(in <-chan structs.Sighting, capturing func() []string, stop <-chan struct{}) {
+	ticker := state.GetClock().Ticker(StreamBatchInterval)
+	defer ticker.Stop()
+	pending := make([]structs.Sighting, 0)
+	started := state.GetClock().Now()
+	flush := func() {
+		state.StartCapture()
+		defer state.EndCapture()
+		byAdapter := make(map[string][]structs.Sighting)
+		for _, adapter := range capturing() {
+			byAdapter[adapter] = make([]structs.Sighting, 0)
+		}
+		for _, s := range pending {
+			byAdapter[s.Adapter] = append(byAdapter[s.Adapter], s)
+		}
+		for adapter, sightings := range byAdapter {
+			recordScan(adapter, started, sightings, structs.ScanOK)
+		}
+		StoreMacs(pending)
+		pending = make([]structs.Sighting, 0)
+		started = state.GetClock().Now()
+	}
+	for {
+		select {
+		case s := <-in:
+			pending = append(pending, s)
+		case <-ticker.C:
+			flush()
+		case <-stop:
+			// Keep what was already sent our way.
+			for {
+				select {
+				case s := <-in:
+					pending = append(pending, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// retryAt fires at `wake`, or straight away if that has already passed.
+// (A mock clock does not fire timers that are already due until it is
+// next moved on.)
+func retryAt(wake time.Time) <-chan time.Time {
+	now := state.GetClock().Now()
+	if wait := wake.Sub(now); wait > 0 {
+		return state.GetClock().After(wait)
+	}
+	due := make(chan time.Time, 1)
+	due <- now
+	return due
+}
+
+// streamAdapter runs one capture on an adapter, tagging its sightings
+// with the adapter they came from, until the capture exits. It returns
+// how many sightings the capture produced.
+func streamAdapter(adapter string, streamFn StreamFn, sightings chan<- structs.Sighting, stop <-chan struct{}) (int, error) {
+	lines := make(chan string, 1024)
+	forwarded := make(chan struct{})
+	count := 0
+	go func() {
+		defer close(forwarded)
+		for line := range lines {
+			s := ParseSighting(line)
+			if !isMAC(s.MAC) {
+				continue
+			}
+			s.Adapter = adapter
+			count += 1
+			select {
+			case sightings <- s:
+			case <-stop:
+			}
+		}
+	}()
+	err := streamFn(adapter, lines, stop)
+	close(lines)
+	<-forwarded
+	return count, err
+}
+
+// StreamShark is the long-running alternative to SimpleShark. It keeps a
+// capture running on the adapter and restarts it (re-running the search
+// and monitor mode setup) whenever it exits. It returns once `stop` is
+// closed and the last batch has been stored.
+func StreamShark(
+	setMonitorFn MonitorFn,
+	searchFn SearchFn,
+	streamFn StreamFn,
+	stop <-chan struct{}) {
+	MultiStreamShark(setMonitorFn, singleDevice(searchFn), streamFn, stop)
+}
+
+// MultiStreamShark is StreamShark for every adapter devicesFn finds. Each
+// adapter gets its own capture and its own Supervisor, so one adapter
+// backing off does not hold up the others; all of them feed the one
+// batch. Discovery is re-run every time something changes.
+func MultiStreamShark(
+	setMonitorFn MonitorFn,
+	devicesFn DevicesFn,
+	streamFn StreamFn,
+	stop <-chan struct{}) {
+
+	// The adapters with a capture running. Read by the batcher.
+	var mu sync.Mutex
+	running := make(map[string]bool)
+	capturing := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		adapters := make([]string, 0)
+		for adapter := range running {
+			adapters = append(adapters, adapter)
+		}
+		return adapters
+	}
+
+	sightings := make(chan structs.Sighting, 1024)
+	batched := make(chan struct{})
+	go func() {
+		batchMACs(sightings, capturing, stop)
+		close(batched)
+	}()
+
+	type exit struct {
+		adapter   string
+		sightings int
+		err       error
+	}
+	exited := make(chan exit)
+	discovery := NewSupervisor("", StreamRestartDelay)
+	supervisors := make(map[string]*Supervisor)
+	for {
+		// When to look again, if something is waiting on a backoff.
+		var wake time.Time
+		later := func(sv *Supervisor) {
+			if next := sv.nextAttempt(); wake.IsZero() || next.Before(wake) {
+				wake = next
+			}
+		}
+
+		devices := existingDevices(devicesFn())
+		if len(devices) == 0 && len(capturing()) == 0 {
+			recordScan("", state.GetClock().Now(), nil, ClassifyFailure(ErrNoDevices))
+			discovery.Failed(ErrNoDevices)
+			later(discovery)
+		} else if discovery.failing() {
+			discovery.Succeeded()
+		}
+
+		for _, dev := range devices {
+			adapter := dev.Logicalname
+			mu.Lock()
+			alreadyRunning := running[adapter]
+			mu.Unlock()
+			if alreadyRunning {
+				continue
+			}
+			sv, ok := supervisors[adapter]
+			if !ok {
+				sv = NewSupervisor(adapter, StreamRestartDelay)
+				supervisors[adapter] = sv
+			}
+			if !sv.Ready() {
+				later(sv)
+				continue
+			}
+			if err := setMonitor(setMonitorFn, dev); err != nil {
+				recordScan(adapter, state.GetClock().Now(), nil, ClassifyFailure(err))
+				sv.Failed(err)
+				later(sv)
+				continue
+			}
+			log.Info().
+				Str("adapter", adapter).
+				Msg("starting streaming capture")
+			state.SetCaptureHealth(adapter, state.CaptureHealth{Healthy: true, Since: state.GetClock().Now()})
+			mu.Lock()
+			running[adapter] = true
+			mu.Unlock()
+			go func(adapter string) {
+				n, err := streamAdapter(adapter, streamFn, sightings, stop)
+				select {
+				case exited <- exit{adapter, n, err}:
+				case <-stop:
+				}
+			}(adapter)
+		}
+
+		var retry <-chan time.Time
+		if !wake.IsZero() {
+			retry = retryAt(wake)
+		}
+		select {
+		case <-stop:
+			<-batched
+			return
+		case e := <-exited:
+			mu.Lock()
+			delete(running, e.adapter)
+			mu.Unlock()
+			err := e.err
+			if err == nil {
+				err = ErrCaptureEnded
+			}
+			sv := supervisors[e.adapter]
+			// A capture that got going before it died starts the
+			// backoff over.
+			if e.sightings > 0 {
+				sv.restart()
+			}
+			sv.Failed(err)
+			recordScan(e.adapter, state.GetClock().Now(), nil, ClassifyFailure(err))
+		case <-retry:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package tlp
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/structs"
+)
+
+// eventually waits for a condition the goroutines under test should
+// reach. The deadline is only there so a broken test fails rather than
+// hangs; nothing waits on it otherwise.
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.After(30 * time.Second)
+	for !cond() {
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for ", what)
+		default:
+			runtime.Gosched()
+		}
+	}
+}
+
+func TestStreamBatchesPerMinute(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+	start := mock.Now()
+
+	var sent int32
+	fakeStream := func(adapter string, out chan<- string, stop <-chan struct{}) error {
+		for _, mac := range []string{"DE:AD:BE:EF:00:00", "DE:AD:BE:EF:00:00", "BE:EF:00:00:00:00", ""} {
+			out <- mac
+		}
+		atomic.StoreInt32(&sent, 1)
+		<-stop
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		StreamShark(fakeMonitorFn, fakeSearchFn, fakeStream, stop)
+		close(done)
+	}()
+
+	eventually(t, "the stream to start", func() bool { return atomic.LoadInt32(&sent) == 1 })
+	// Whether the batcher has the sightings by the tick or not, they are
+	// stored by the time it stops, at the end of the batch.
+	mock.Add(StreamBatchInterval)
+	close(stop)
+	<-done
+
+	macs := state.GetMACs()
+	if len(macs) != 2 {
+		t.Fatal("expected 2 devices, found ", len(macs))
+	}
+	end := start.Add(StreamBatchInterval).Unix()
+	if se := macs[state.HashMAC("DE:AD:BE:EF:00:00")]; se.Start != end || se.End != end {
+		t.Error("sighting should be stamped at the end of the batch ", se)
+	}
+}
+
+func TestBatchMACsPerInterval(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+
+	// Unbuffered, so each send returns once the batcher has the sighting.
+	in := make(chan structs.Sighting)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		batchMACs(in, func() []string { return nil }, stop)
+		close(done)
+	}()
+
+	in <- structs.Sighting{MAC: "DE:AD:BE:EF:00:00", Adapter: "fakewan0"}
+	in <- structs.Sighting{MAC: "BE:EF:00:00:00:00", Adapter: "fakewan0"}
+	if len(state.GetMACs()) != 0 {
+		t.Error("sightings should wait for the end of the batch")
+	}
+	mock.Add(StreamBatchInterval)
+	eventually(t, "the batch to be stored", func() bool { return len(state.GetMACs()) == 2 })
+
+	in <- structs.Sighting{MAC: "C0:FF:EE:00:00:00", Adapter: "fakewan0"}
+	close(stop)
+	<-done
+	if len(state.GetMACs()) != 3 {
+		t.Error("what is pending should be stored on stop, found ", len(state.GetMACs()))
+	}
+}
+
+func TestStreamRestartsWhenCaptureExits(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+
+	var runs int32
+	fakeStream := func(adapter string, out chan<- string, stop <-chan struct{}) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		StreamShark(fakeMonitorFn, fakeSearchFn, fakeStream, stop)
+		close(done)
+	}()
+
+	// The restart is timed from when the exit is recorded.
+	eventually(t, "the first exit", func() bool { return state.GetCaptureHealth()["fakewan0"].Failures == 1 })
+	mock.Add(StreamRestartDelay)
+	eventually(t, "a restart", func() bool { return atomic.LoadInt32(&runs) == 2 })
+	close(stop)
+	<-done
+}
+
+func TestMultiStreamShark(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+
+	var sent int32
+	fakeStream := func(adapter string, out chan<- string, stop <-chan struct{}) error {
+		out <- "DE:AD:BE:EF:00:00"
+		if adapter == "fakewan1" {
+			out <- "C0:FF:EE:00:00:00"
+		}
+		atomic.AddInt32(&sent, 1)
+		<-stop
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		MultiStreamShark(fakeMonitorFn, fakeDevicesFn, fakeStream, stop)
+		close(done)
+	}()
+
+	eventually(t, "both streams to start", func() bool { return atomic.LoadInt32(&sent) == 2 })
+	// Until everything sent has been through a batch.
+	eventually(t, "both devices to be stored", func() bool {
+		mock.Add(StreamBatchInterval)
+		return len(state.GetMACs()) == 2
+	})
+	close(stop)
+	<-done
+
+	if len(state.GetMACs()) != 2 {
+		t.Error("expected 2 devices, found ", len(state.GetMACs()))
+	}
+	stats := state.GetAdapterStats()
+	if stats["fakewan0"].Sightings != 1 || stats["fakewan1"].Sightings != 2 {
+		t.Error("unexpected adapter stats ", stats)
+	}
+}
@@ -0,0 +1,201 @@
+package tlp
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+)
+
+var ErrNoDevices = errors.New("no wifi devices found")
+
+// ErrCaptureEnded is returned when a streaming capture stops by itself.
+var ErrCaptureEnded = errors.New("capture ended")
+
+// How a capture failed. Each calls for a different fix, so they are
+// logged and kept in the capture health separately.
+const (
+	// The adapter was unplugged, renamed, or will not go into monitor mode.
+	FailureAdapter = "adapter"
+	// We are not allowed to capture, or to change the adapter's mode.
+	FailurePermission = "permission"
+	// tshark, ip, iw, or WlanHelper is not where the config says.
+	FailureMissingTool = "missing_tool"
+	FailureOther       = "other"
+)
+
+// The most we back off for after repeated failures.
+const CaptureRetryMax = 15 * time.Minute
+
+// ClassifyFailure sorts a capture error into one of the Failure* kinds.
+// Most of what we get back is a tool's stderr, so this is by the text.
+func ClassifyFailure(err error) string {
+	var execErr *exec.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNoDevices):
+		return FailureAdapter
+	case errors.As(err, &execErr), errors.Is(err, os.ErrNotExist):
+		return FailureMissingTool
+	case errors.Is(err, os.ErrPermission):
+		return FailurePermission
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"permission denied", "operation not permitted", "don't have permission"} {
+		if strings.Contains(msg, s) {
+			return FailurePermission
+		}
+	}
+	for _, s := range []string{"no such device", "cannot find device", "doesn't exist", "does not exist", "network is down", "device not found"} {
+		if strings.Contains(msg, s) {
+			return FailureAdapter
+		}
+	}
+	return FailureOther
+}
+
+// A Supervisor decides when a failing capture gets tried again, doubling
+// the wait after each failure in a row up to CaptureRetryMax, and keeps
+// the adapter's capture health up to date.
+type Supervisor struct {
+	adapter string
+	min     time.Duration
+	// mu guards failures and next. A capture can still be finishing
+	// when the next is due.
+	mu       sync.Mutex
+	failures int
+	next     time.Time
+}
+
+func NewSupervisor(adapter string, min time.Duration) *Supervisor {
+	return &Supervisor{adapter: adapter, min: min}
+}
+
+// Ready says whether the backoff has run out.
+func (sv *Supervisor) Ready() bool {
+	return !state.GetClock().Now().Before(sv.nextAttempt())
+}
+
+// nextAttempt is when the backoff runs out.
+func (sv *Supervisor) nextAttempt() time.Time {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.next
+}
+
+// failing says whether the last attempt failed.
+func (sv *Supervisor) failing() bool {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.failures > 0
+}
+
+// restart forgets earlier failures, so the next one starts the backoff
+// over.
+func (sv *Supervisor) restart() {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.failures = 0
+}
+
+// Failed records a failure and returns how long to wait before trying
+// again.
+func (sv *Supervisor) Failed(err error) time.Duration {
+	if err == nil {
+		err = ErrCaptureEnded
+	}
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	delay := sv.min
+	for i := 0; i < sv.failures && delay < CaptureRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > CaptureRetryMax {
+		delay = CaptureRetryMax
+	}
+	sv.failures += 1
+	now := state.GetClock().Now()
+	sv.next = now.Add(delay)
+
+	kind := ClassifyFailure(err)
+	log.Error().
+		Err(err).
+		Str("adapter", sv.adapter).
+		Str("failure", kind).
+		Int("failures", sv.failures).
+		Str("retry", delay.String()).
+		Msg("capture failed; backing off")
+	state.SetCaptureHealth(sv.adapter, state.CaptureHealth{
+		Failure:     kind,
+		Error:       err.Error(),
+		Failures:    sv.failures,
+		Since:       now,
+		NextAttempt: sv.next,
+	})
+	return delay
+}
+
+// Succeeded resets the backoff.
+func (sv *Supervisor) Succeeded() {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if sv.failures > 0 {
+		log.Info().
+			Str("adapter", sv.adapter).
+			Int("failures", sv.failures).
+			Msg("capture recovered")
+	}
+	sv.failures = 0
+	sv.next = time.Time{}
+	state.SetCaptureHealth(sv.adapter, state.CaptureHealth{
+		Healthy: true,
+		Since:   state.GetClock().Now(),
+	})
+}
+
+// LogCaptureHealth writes how capture is going on every adapter to the
+// log, so a counter that has quietly stopped counting shows up there.
+func LogCaptureHealth() {
+	for adapter, h := range state.GetCaptureHealth() {
+		if h.Healthy {
+			log.Info().
+				Str("adapter", adapter).
+				Time("since", h.Since).
+				Msg("capture healthy")
+			continue
+		}
+		log.Warn().
+			Str("adapter", adapter).
+			Str("failure", h.Failure).
+			Str("error", h.Error).
+			Int("failures", h.Failures).
+			Time("since", h.Since).
+			Time("next_attempt", h.NextAttempt).
+			Msg("capture unhealthy")
+	}
+	log.Info().
+		Bool("healthy", state.CaptureHealthy()).
+		Msg("capture health")
+}
+
+// Attempt runs a capture unless we are still backing off from the last
+// failure, and records how it went. It returns the capture's error, or
+// nil if it was not yet time to try.
+func (sv *Supervisor) Attempt(capture func() error) error {
+	if !sv.Ready() {
+		return nil
+	}
+	err := capture()
+	if err != nil {
+		sv.Failed(err)
+	} else {
+		sv.Succeeded()
+	}
+	return err
+}
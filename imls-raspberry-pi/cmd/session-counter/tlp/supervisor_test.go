@@ -0,0 +1,195 @@
+package tlp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gsa.gov/18f/internal/state"
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	_, missing := exec.LookPath("no-such-tshark")
+	for _, c := range []struct {
+		err  error
+		kind string
+	}{
+		{missing, FailureMissingTool},
+		{&os.PathError{Op: "fork/exec", Path: "/usr/bin/tshark", Err: os.ErrNotExist}, FailureMissingTool},
+		{fmt.Errorf("tshark exited: exit status 1: %s", "wlan1: You don't have permission to capture on that device"), FailurePermission},
+		{errors.New("/usr/sbin/ip link set wlan1 down: exit status 1: Cannot find device \"wlan1\"\nNo such device"), FailureAdapter},
+		{ErrNoDevices, FailureAdapter},
+		{ErrCaptureEnded, FailureOther},
+	} {
+		if got := ClassifyFailure(c.err); got != c.kind {
+			t.Error("expected ", c.kind, " for ", c.err, ", got ", got)
+		}
+	}
+}
+
+func TestSupervisorBackoff(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+	sv := NewSupervisor("fakewan9", time.Minute)
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		if got := sv.Failed(ErrNoDevices); got != want {
+			t.Error("expected to back off for ", want, ", got ", got)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		sv.Failed(ErrNoDevices)
+	}
+	if got := sv.Failed(ErrNoDevices); got != CaptureRetryMax {
+		t.Error("the backoff should be capped, got ", got)
+	}
+	h := state.GetCaptureHealth()["fakewan9"]
+	if h.Healthy || h.Failure != FailureAdapter || h.Failures != 14 {
+		t.Error("unexpected health ", h)
+	}
+
+	runs := 0
+	capture := func() error { runs += 1; return nil }
+	sv.Attempt(capture)
+	if runs != 0 {
+		t.Error("should not capture while backing off")
+	}
+	mock.Add(CaptureRetryMax)
+	sv.Attempt(capture)
+	if runs != 1 || !state.GetCaptureHealth()["fakewan9"].Healthy {
+		t.Error("should capture, and be healthy, once the backoff runs out")
+	}
+	if got := sv.Failed(ErrNoDevices); got != time.Minute {
+		t.Error("a good capture should reset the backoff, got ", got)
+	}
+}
+
+func TestLogCaptureHealth(t *testing.T) {
+	setup(t)
+	var out bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&out)
+	defer func() { log.Logger = logger }()
+
+	NewSupervisor("fakewan7", time.Minute).Succeeded()
+	NewSupervisor("fakewan8", time.Minute).Failed(ErrNoDevices)
+	out.Reset()
+	LogCaptureHealth()
+	for _, want := range []string{
+		`"adapter":"fakewan7","since"`,
+		`"adapter":"fakewan8","failure":"adapter"`,
+		`"healthy":false`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Error("expected ", want, " in the log, got ", out.String())
+		}
+	}
+}
+
+func TestMultiSharkPartialFailure(t *testing.T) {
+	setup(t)
+	monitorFn := func(d *models.Device) error {
+		if d.Logicalname == "fakewan1" {
+			return errors.New("Cannot find device \"fakewan1\"")
+		}
+		return nil
+	}
+	if err := MultiShark(monitorFn, fakeDevicesFn, fakeShark2); err != nil {
+		t.Error("one good adapter is enough, got ", err)
+	}
+	if len(state.GetMACs()) != 2 {
+		t.Error("the good adapter's sightings should be stored")
+	}
+	health := state.GetCaptureHealth()
+	if !health["fakewan0"].Healthy || health["fakewan1"].Failure != FailureAdapter {
+		t.Error("unexpected health ", health)
+	}
+
+	failing := func(string) ([]string, error) { return nil, errors.New("tshark exited") }
+	if err := MultiShark(fakeMonitorFn, fakeDevicesFn, failing); err == nil {
+		t.Error("expected an error when no adapter could capture")
+	}
+}
+
+func TestMultiSharkBacksOffPerAdapter(t *testing.T) {
+	setup(t)
+	clearScans(t)
+	mock := state.GetClock().(*clock.Mock)
+	tries := make(map[string]int)
+	monitorFn := func(d *models.Device) error {
+		tries[d.Logicalname] += 1
+		if d.Logicalname == "fakewan1" {
+			return errors.New("Cannot find device \"fakewan1\"")
+		}
+		return nil
+	}
+
+	MultiShark(monitorFn, fakeDevicesFn, fakeShark2)
+	// The unplugged adapter waits out its backoff; the other carries on.
+	MultiShark(monitorFn, fakeDevicesFn, fakeShark2)
+	if tries["fakewan0"] != 2 || tries["fakewan1"] != 1 {
+		t.Error("expected only the working adapter to be tried again, got ", tries)
+	}
+	if n := len(sessionScans(t)); n != 3 {
+		t.Error("an adapter backing off should not record a scan, found ", n)
+	}
+	h := state.GetCaptureHealth()["fakewan1"]
+	if h.Failures != 1 || !h.NextAttempt.Equal(mock.Now().Add(BurstRetryDelay)) {
+		t.Error("unexpected health ", h)
+	}
+
+	mock.Add(BurstRetryDelay)
+	MultiShark(monitorFn, fakeDevicesFn, fakeShark2)
+	if tries["fakewan1"] != 2 {
+		t.Error("expected the adapter to be tried once its backoff ran out, got ", tries)
+	}
+	if h := state.GetCaptureHealth()["fakewan1"]; h.Failures != 2 {
+		t.Error("expected the backoff to grow, found ", h)
+	}
+}
+
+func TestStreamBacksOff(t *testing.T) {
+	setup(t)
+	mock := state.GetClock().(*clock.Mock)
+
+	var runs int32
+	fakeStream := func(adapter string, out chan<- string, stop <-chan struct{}) error {
+		atomic.AddInt32(&runs, 1)
+		return errors.New("tshark exited: exit status 2")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		StreamShark(fakeMonitorFn, fakeSearchFn, fakeStream, stop)
+		close(done)
+	}()
+
+	failures := func(n int) func() bool {
+		return func() bool { return state.GetCaptureHealth()["fakewan0"].Failures == n }
+	}
+	eventually(t, "the first failure", failures(1))
+	mock.Add(StreamRestartDelay)
+	eventually(t, "a restart", func() bool { return atomic.LoadInt32(&runs) == 2 })
+	eventually(t, "the second failure", failures(2))
+	// The second failure waits twice as long.
+	h := state.GetCaptureHealth()["fakewan0"]
+	if !h.NextAttempt.Equal(mock.Now().Add(2 * StreamRestartDelay)) {
+		t.Error("restarting without backing off, at ", h.NextAttempt)
+	}
+	mock.Add(StreamRestartDelay)
+	mock.Add(StreamRestartDelay)
+	eventually(t, "a second restart", func() bool { return atomic.LoadInt32(&runs) == 3 })
+	close(stop)
+	<-done
+}
@@ -0,0 +1,240 @@
+// Package audit looks through the data a device keeps for anything that
+// could identify a patron, or the device, where it should not be. It
+// backs up the claim that no PII is logged with something a library can
+// run for itself.
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"gsa.gov/18f/internal/zero-log-sentry"
+)
+
+// The kinds of identifier an audit looks for.
+const (
+	KindMAC    = "mac"
+	KindSerial = "serial"
+	KindEmail  = "email"
+)
+
+// SerialColumn is where the device's serial is meant to be: it says
+// which device the counts came from.
+const SerialColumn = "pi_serial"
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// A Finding is one identifier, and where it was. The identifier itself
+// is redacted; the report should not leak what it found.
+type Finding struct {
+	Path  string
+	Where string
+	Kind  string
+	Match string
+}
+
+type Report struct {
+	Scanned  []string
+	Findings []Finding
+	// Files that could not be looked through, and why.
+	Skipped map[string]string
+}
+
+// Clean is true if everything was looked through and nothing was found.
+func (r Report) Clean() bool {
+	return len(r.Findings) == 0 && len(r.Skipped) == 0
+}
+
+func (r Report) Write(w io.Writer) {
+	for _, f := range r.Findings {
+		fmt.Fprintf(w, "%s: %s: %s %s\n", f.Path, f.Where, f.Kind, f.Match)
+	}
+	skipped := make([]string, 0)
+	for path := range r.Skipped {
+		skipped = append(skipped, path)
+	}
+	sort.Strings(skipped)
+	for _, path := range skipped {
+		fmt.Fprintf(w, "%s: not audited: %s\n", path, r.Skipped[path])
+	}
+	fmt.Fprintf(w, "audited %d files: %d identifiers found, %d files not audited\n",
+		len(r.Scanned), len(r.Findings), len(r.Skipped))
+}
+
+// DecryptFn writes a plain copy of a database sealed at rest to `out`.
+type DecryptFn func(in string, out string) error
+
+type Auditor struct {
+	serial  string
+	decrypt DecryptFn
+	sealed  string
+	report  Report
+	seen    map[string]bool
+}
+
+// NewAuditor audits for the device with the given serial. Files ending
+// in `sealed` are decrypted with `decrypt` first.
+func NewAuditor(serial string, sealed string, decrypt DecryptFn) *Auditor {
+	return &Auditor{
+		serial:  serial,
+		decrypt: decrypt,
+		sealed:  sealed,
+		report:  Report{Scanned: make([]string, 0), Findings: make([]Finding, 0), Skipped: make(map[string]string)},
+		seen:    make(map[string]bool),
+	}
+}
+
+func (a *Auditor) Report() Report {
+	return a.report
+}
+
+// Audit looks through a file, or everything under a directory. Each file
+// is only audited once, however it is reached.
+func (a *Auditor) Audit(path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			if a.seen[abs] {
+				return nil
+			}
+			a.seen[abs] = true
+		}
+		a.auditFile(p)
+		return nil
+	})
+}
+
+func (a *Auditor) auditFile(path string) {
+	if a.sealed != "" && strings.HasSuffix(path, a.sealed) {
+		a.auditSealed(path)
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		a.report.Skipped[path] = err.Error()
+		return
+	}
+	a.report.Scanned = append(a.report.Scanned, path)
+	if bytes.HasPrefix(data, sqliteHeader) {
+		if err := a.auditDatabase(path, path); err != nil {
+			a.report.Skipped[path] = err.Error()
+		}
+		return
+	}
+	// Anything else (logs, pages, images, journals) is looked through
+	// a line at a time.
+	for ndx, line := range bytes.Split(data, []byte("\n")) {
+		a.check(path, fmt.Sprintf("line %d", ndx+1), "", string(line))
+	}
+}
+
+func (a *Auditor) auditSealed(path string) {
+	if a.decrypt == nil {
+		a.report.Skipped[path] = "encrypted"
+		return
+	}
+	dir, err := ioutil.TempDir("", "privacy-audit")
+	if err != nil {
+		a.report.Skipped[path] = err.Error()
+		return
+	}
+	defer os.RemoveAll(dir)
+	plain := filepath.Join(dir, "audit.sqlite")
+	if err := a.decrypt(path, plain); err != nil {
+		a.report.Skipped[path] = err.Error()
+		return
+	}
+	a.report.Scanned = append(a.report.Scanned, path)
+	if err := a.auditDatabase(path, plain); err != nil {
+		a.report.Skipped[path] = err.Error()
+	}
+}
+
+// auditDatabase looks through every value in every table of the SQLite
+// database at `file`, reporting findings against `path`.
+func (a *Auditor) auditDatabase(path string, file string) error {
+	db, err := sqlx.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tables := []string{}
+	err = db.Select(&tables, "SELECT name FROM sqlite_master WHERE type='table'")
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		rows, err := db.Queryx(fmt.Sprintf("SELECT * FROM %q", table))
+		if err != nil {
+			return err
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		row := 0
+		for rows.Next() {
+			row += 1
+			values, err := rows.SliceScan()
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			for ndx, v := range values {
+				var s string
+				switch v := v.(type) {
+				case string:
+					s = v
+				case []byte:
+					s = string(v)
+				default:
+					continue
+				}
+				a.check(path, fmt.Sprintf("%s.%s row %d", table, columns[ndx], row), columns[ndx], s)
+			}
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// check reports any identifiers in `s`. The device's serial is expected
+// in SerialColumn, and only there.
+func (a *Auditor) check(path string, where string, column string, s string) {
+	for _, mac := range zls.MACPattern.FindAllString(s, -1) {
+		a.found(path, where, KindMAC, zls.RedactMAC(mac))
+	}
+	for _, email := range emailPattern.FindAllString(s, -1) {
+		a.found(path, where, KindEmail, "***"+email[strings.LastIndex(email, "@"):])
+	}
+	if a.serial != "" && column != SerialColumn && strings.Contains(s, a.serial) {
+		a.found(path, where, KindSerial, "the device serial")
+	}
+}
+
+func (a *Auditor) found(path string, where string, kind string, match string) {
+	a.report.Findings = append(a.report.Findings, Finding{
+		Path:  path,
+		Where: where,
+		Kind:  kind,
+		Match: match,
+	})
+}
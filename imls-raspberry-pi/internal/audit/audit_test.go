@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"gsa.gov/18f/internal/zero-log-sentry"
+)
+
+func writeDB(t *testing.T, path string, mac string) {
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.MustExec("CREATE TABLE durations (pi_serial TEXT, session_id TEXT, patron_index INTEGER, note TEXT)")
+	db.MustExec("INSERT INTO durations VALUES (?, ?, ?, ?)", "1000000012345678", "42", 0, "")
+	db.MustExec("INSERT INTO durations VALUES (?, ?, ?, ?)", "1000000012345678", "42", 1, mac)
+}
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDB(t, filepath.Join(dir, "clean.sqlite"), "")
+	writeDB(t, filepath.Join(dir, "dirty.sqlite"), "saw DE:AD:BE:EF:00:00")
+	logs := filepath.Join(dir, "logs")
+	os.Mkdir(logs, 0755)
+	ioutil.WriteFile(filepath.Join(logs, "session-counter.log"), []byte(
+		"{\"level\":\"info\",\"message\":\"starting\"}\n"+
+			"{\"level\":\"error\",\"mac\":\"de-ad-be-ef-00-01\",\"serial\":\"1000000012345678\"}\n"+
+			"{\"level\":\"info\",\"contact\":\"someone@example.com\",\"mac\":\""+zls.RedactMAC("DE:AD:BE:EF:00:02")+"\"}\n"), 0644)
+
+	a := NewAuditor("1000000012345678", ".enc", nil)
+	if err := a.Audit(dir); err != nil {
+		t.Fatal(err)
+	}
+	// Reaching the same file twice does not count it twice.
+	a.Audit(filepath.Join(dir, "dirty.sqlite"))
+
+	report := a.Report()
+	if len(report.Scanned) != 3 {
+		t.Error("expected 3 files audited, got ", report.Scanned)
+	}
+	expected := []Finding{
+		{filepath.Join(dir, "dirty.sqlite"), "durations.note row 2", KindMAC, zls.RedactMAC("DE:AD:BE:EF:00:00")},
+		{filepath.Join(logs, "session-counter.log"), "line 2", KindMAC, zls.RedactMAC("DE:AD:BE:EF:00:01")},
+		{filepath.Join(logs, "session-counter.log"), "line 2", KindSerial, "the device serial"},
+		{filepath.Join(logs, "session-counter.log"), "line 3", KindEmail, "***@example.com"},
+	}
+	if len(report.Findings) != len(expected) {
+		t.Fatal("expected ", expected, ", got ", report.Findings)
+	}
+	for ndx, f := range expected {
+		if report.Findings[ndx] != f {
+			t.Error("expected ", f, ", got ", report.Findings[ndx])
+		}
+	}
+	if report.Clean() {
+		t.Error("the report should not be clean")
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if strings.Contains(out.String(), "DE:AD:BE:EF") || strings.Contains(out.String(), "someone") {
+		t.Error("the report should not repeat what it found: ", out.String())
+	}
+}
+
+func TestAuditSealed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeDB(t, filepath.Join(dir, "plain"), "")
+	os.Rename(filepath.Join(dir, "plain"), filepath.Join(dir, "durations.sqlite.enc"))
+
+	a := NewAuditor("", ".enc", nil)
+	a.Audit(dir)
+	if report := a.Report(); report.Clean() || len(report.Skipped) != 1 {
+		t.Error("an encrypted database that cannot be read should not pass ", report)
+	}
+
+	// Standing in for decryption.
+	copyFile := func(in string, out string) error {
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(out, data, 0600)
+	}
+	a = NewAuditor("1000000012345678", ".enc", copyFile)
+	a.Audit(dir)
+	if report := a.Report(); !report.Clean() || len(report.Scanned) != 1 {
+		t.Error("expected the decrypted database to be clean ", report)
+	}
+}
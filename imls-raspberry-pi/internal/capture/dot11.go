@@ -0,0 +1,263 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// 802.11 frame types, from the frame control field.
+const (
+	TypeManagement = 0
+	TypeControl    = 1
+	TypeData       = 2
+)
+
+var ErrShortFrame = errors.New("capture: frame too short")
+
+// Frame is the small part of an 802.11 frame that we care about.
+type Frame struct {
+	Timestamp time.Time
+	Type      uint8
+	Subtype   uint8
+	// The distribution system bits: ToDS is set on frames a client sends
+	// to the network, FromDS on frames the network sends to a client.
+	ToDS   bool
+	FromDS bool
+	// SA is the source address as tshark reports it in `wlan.sa`. It is
+	// nil for frames that do not carry one (most control frames).
+	SA net.HardwareAddr
+	// BSSID is the network the frame belongs to, as tshark reports it in
+	// `wlan.bssid`. It is nil when the frame does not say (control
+	// frames, and data frames between two access points).
+	BSSID net.HardwareAddr
+	// SSID is the network name from a beacon or probe response
+	// (`wlan.ssid`), and empty for every other kind of frame.
+	SSID string
+	// Frequency in MHz from the radiotap header (`radiotap.channel.freq`),
+	// or zero if the capture did not include it.
+	Frequency int
+	// Signal in dBm at the antenna (`radiotap.dbm_antsignal`), or zero
+	// if the capture did not include it.
+	Signal int
+	// Elements are the information elements of a probe request, in the
+	// order they were sent. Nil for every other kind of frame.
+	Elements []Element
+}
+
+// An Element is one tagged information element from a management frame.
+type Element struct {
+	ID   uint8
+	Data []byte
+}
+
+// Information element IDs.
+const (
+	ElementSSID            = 0
+	ElementSupportedRates  = 1
+	ElementHTCapabilities  = 45
+	ElementExtendedRates   = 50
+	ElementVHTCapabilities = 191
+	ElementVendor          = 221
+)
+
+const (
+	subtypeProbeRequest  = 4
+	subtypeProbeResponse = 5
+	subtypeBeacon        = 8
+)
+
+// Beacons and probe responses have a timestamp, beacon interval, and
+// capability field ahead of their elements.
+const advertisementFixedLength = 12
+
+// ssid returns the SSID element, if there is one.
+func ssid(elems []Element) string {
+	for _, e := range elems {
+		if e.ID == ElementSSID {
+			return string(e.Data)
+		}
+	}
+	return ""
+}
+
+// parseElements reads tagged elements until the data runs out. A
+// truncated element at the end is dropped.
+func parseElements(data []byte) []Element {
+	elems := make([]Element, 0)
+	for len(data) >= 2 {
+		id, length := data[0], int(data[1])
+		if 2+length > len(data) {
+			break
+		}
+		elems = append(elems, Element{ID: id, Data: data[2 : 2+length]})
+		data = data[2+length:]
+	}
+	return elems
+}
+
+// Radiotap fields, in the order of their bits in the present word, up to
+// the last one we read. Each is {alignment, size}.
+// https://www.radiotap.org/fields/defined
+var radiotapFields = [][2]int{
+	{8, 8}, // TSFT
+	{1, 1}, // flags
+	{1, 1}, // rate
+	{2, 4}, // channel
+	{2, 2}, // FHSS
+	{1, 1}, // dBm antenna signal
+}
+
+const (
+	radiotapChannel = 3
+	radiotapSignal  = 5
+	// Bit 31 of a present word says another present word follows.
+	radiotapExt = 1 << 31
+)
+
+// parseRadiotap fills in the radiotap fields we use and returns the
+// length of the header.
+func parseRadiotap(data []byte, f *Frame) (int, error) {
+	if len(data) < 8 || data[0] != 0 {
+		return 0, errors.New("capture: bad radiotap header")
+	}
+	rtlen := int(binary.LittleEndian.Uint16(data[2:4]))
+	if rtlen < 8 || rtlen > len(data) {
+		return 0, errors.New("capture: bad radiotap length")
+	}
+	hdr := data[:rtlen]
+
+	// Skip over any extended present words; we only read fields from
+	// the first one.
+	present := binary.LittleEndian.Uint32(hdr[4:8])
+	offset := 8
+	for word := present; word&radiotapExt != 0; {
+		if offset+4 > rtlen {
+			return rtlen, nil
+		}
+		word = binary.LittleEndian.Uint32(hdr[offset : offset+4])
+		offset += 4
+	}
+
+	for bit, field := range radiotapFields {
+		if present&(1<<uint(bit)) == 0 {
+			continue
+		}
+		align, size := field[0], field[1]
+		offset = (offset + align - 1) &^ (align - 1)
+		if offset+size > rtlen {
+			break
+		}
+		switch bit {
+		case radiotapChannel:
+			f.Frequency = int(binary.LittleEndian.Uint16(hdr[offset : offset+2]))
+		case radiotapSignal:
+			f.Signal = int(int8(hdr[offset]))
+		}
+		offset += size
+	}
+	return rtlen, nil
+}
+
+// Channel converts a frequency in MHz to an 802.11 channel number.
+// It returns zero for frequencies it does not recognize.
+func Channel(freq int) int {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq < 2484:
+		return (freq - 2407) / 5
+	case freq >= 5955 && freq <= 7115:
+		return (freq - 5950) / 5
+	case freq >= 5000 && freq < 5955:
+		return (freq - 5000) / 5
+	}
+	return 0
+}
+
+// Frequency converts a channel number to its frequency in MHz. Channels
+// 1-14 are taken to be 2.4 GHz, and everything else 5 GHz.
+func Frequency(channel int) int {
+	switch {
+	case channel == 14:
+		return 2484
+	case channel >= 1 && channel < 14:
+		return 2407 + channel*5
+	case channel > 14:
+		return 5000 + channel*5
+	}
+	return 0
+}
+
+// Decode pulls the 802.11 header out of a captured packet, stripping
+// the radiotap header if there is one.
+func Decode(p Packet) (Frame, error) {
+	data := p.Data
+	f := Frame{Timestamp: p.Timestamp}
+	switch p.LinkType {
+	case LinkTypeRadiotap:
+		rtlen, err := parseRadiotap(data, &f)
+		if err != nil {
+			return Frame{}, err
+		}
+		data = data[rtlen:]
+	case LinkTypeIEEE80211:
+	default:
+		return Frame{}, fmt.Errorf("capture: unsupported link type %d", p.LinkType)
+	}
+
+	if len(data) < 2 {
+		return f, ErrShortFrame
+	}
+	f.Type = (data[0] >> 2) & 0x03
+	f.Subtype = (data[0] >> 4) & 0x0f
+	f.ToDS = data[1]&0x01 != 0
+	f.FromDS = data[1]&0x02 != 0
+
+	switch f.Type {
+	case TypeManagement:
+		if len(data) < 24 {
+			return f, ErrShortFrame
+		}
+		f.SA = address(data, 10)
+		f.BSSID = address(data, 16)
+		switch f.Subtype {
+		case subtypeProbeRequest:
+			// Probe requests have no fixed fields before the elements.
+			f.Elements = parseElements(data[24:])
+		case subtypeBeacon, subtypeProbeResponse:
+			if len(data) >= 24+advertisementFixedLength {
+				f.SSID = ssid(parseElements(data[24+advertisementFixedLength:]))
+			}
+		}
+	case TypeData:
+		if len(data) < 24 {
+			return f, ErrShortFrame
+		}
+		switch {
+		case f.ToDS && f.FromDS:
+			if len(data) < 30 {
+				return f, ErrShortFrame
+			}
+			f.SA = address(data, 24)
+		case f.ToDS:
+			f.SA = address(data, 10)
+			f.BSSID = address(data, 4)
+		case f.FromDS:
+			f.SA = address(data, 16)
+			f.BSSID = address(data, 10)
+		default:
+			f.SA = address(data, 10)
+			f.BSSID = address(data, 16)
+		}
+	}
+	return f, nil
+}
+
+func address(data []byte, offset int) net.HardwareAddr {
+	addr := make(net.HardwareAddr, 6)
+	copy(addr, data[offset:offset+6])
+	return addr
+}
@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"testing"
+)
+
+func TestDecodeSourceAddresses(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	// What tshark reports for `-e wlan.sa` on the same file.
+	expected := []string{
+		"00:11:22:33:44:55", // beacon
+		"da:a1:19:00:00:01", // probe request
+		"6e:00:00:00:00:02", // probe request
+		"f0:18:98:00:00:01", // probe request
+		"f0:18:98:00:00:01", // data, to the DS
+		"00:aa:bb:cc:dd:ee", // data, from the DS
+		"",                  // ack
+		"b8:27:eb:00:00:04", // association request
+	}
+	for i, want := range expected {
+		f, err := Decode(packets[i])
+		if err != nil {
+			t.Fatal("packet ", i, ": ", err)
+		}
+		got := ""
+		if f.SA != nil {
+			got = f.SA.String()
+		}
+		if got != want {
+			t.Error("packet ", i, ": expected ", want, " got ", got)
+		}
+	}
+}
+
+func TestDecodeTypes(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	f, _ := Decode(packets[0])
+	if f.Type != TypeManagement || f.Subtype != 8 {
+		t.Error("beacon decoded as ", f.Type, f.Subtype)
+	}
+	f, _ = Decode(packets[4])
+	if f.Type != TypeData || !f.ToDS || f.FromDS {
+		t.Error("data to the DS decoded as ", f.Type, f.ToDS, f.FromDS)
+	}
+	f, _ = Decode(packets[5])
+	if f.Type != TypeData || f.ToDS || !f.FromDS {
+		t.Error("data from the DS decoded as ", f.Type, f.ToDS, f.FromDS)
+	}
+	f, _ = Decode(packets[6])
+	if f.Type != TypeControl {
+		t.Error("ack decoded as ", f.Type, f.Subtype)
+	}
+}
+
+func TestDecodeShortFrame(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	if _, err := Decode(packets[8]); err != ErrShortFrame {
+		t.Error("expected a short frame error, got ", err)
+	}
+}
+
+func TestDecodeFrequency(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	expected := []int{2437, 2437, 2437, 2412, 2437, 2437, 2437, 5180}
+	for i, want := range expected {
+		f, _ := Decode(packets[i])
+		if f.Frequency != want {
+			t.Error("packet ", i, ": expected ", want, " MHz, got ", f.Frequency)
+		}
+	}
+}
+
+func TestChannelFrequency(t *testing.T) {
+	for _, c := range []struct{ channel, freq int }{
+		{1, 2412}, {6, 2437}, {11, 2462}, {14, 2484}, {36, 5180}, {165, 5825},
+	} {
+		if Channel(c.freq) != c.channel {
+			t.Error(c.freq, " MHz should be channel ", c.channel, ", got ", Channel(c.freq))
+		}
+		if Frequency(c.channel) != c.freq {
+			t.Error("channel ", c.channel, " should be ", c.freq, " MHz, got ", Frequency(c.channel))
+		}
+	}
+}
+
+func TestDecodeSignal(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	expected := []int{-40, -70, -72, -55, -50, -45, -45, -88}
+	for i, want := range expected {
+		f, _ := Decode(packets[i])
+		if f.Signal != want {
+			t.Error("packet ", i, ": expected ", want, " dBm, got ", f.Signal)
+		}
+	}
+}
+
+func TestDecodeElements(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	f, _ := Decode(packets[1])
+	ids := make([]uint8, 0)
+	for _, e := range f.Elements {
+		ids = append(ids, e.ID)
+	}
+	if len(ids) != 5 || ids[0] != 0 || ids[1] != ElementSupportedRates || ids[4] != ElementVendor {
+		t.Error("unexpected elements in probe request ", ids)
+	}
+	if len(f.Elements[1].Data) != 8 || f.Elements[1].Data[0] != 0x02 {
+		t.Error("unexpected supported rates ", f.Elements[1].Data)
+	}
+	if f, _ := Decode(packets[0]); f.Elements != nil {
+		t.Error("only probe requests should have elements")
+	}
+}
+
+func TestDecodeBSSID(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	// What tshark reports for `-e wlan.bssid` on the same file.
+	expected := []string{
+		"00:11:22:33:44:55", // beacon
+		"ff:ff:ff:ff:ff:ff", // probe request
+		"ff:ff:ff:ff:ff:ff", // probe request
+		"ff:ff:ff:ff:ff:ff", // probe request
+		"00:11:22:33:44:55", // data, to the DS
+		"00:11:22:33:44:55", // data, from the DS
+		"",                  // ack
+		"00:11:22:33:44:55", // association request
+	}
+	for i, want := range expected {
+		f, _ := Decode(packets[i])
+		got := ""
+		if f.BSSID != nil {
+			got = f.BSSID.String()
+		}
+		if got != want {
+			t.Error("packet ", i, ": expected ", want, " got ", got)
+		}
+	}
+	if f, _ := Decode(packets[0]); f.SSID != "Library" {
+		t.Error("expected the beacon's SSID, got ", f.SSID)
+	}
+	if f, _ := Decode(packets[1]); f.SSID != "" {
+		t.Error("only beacons and probe responses should carry an SSID")
+	}
+}
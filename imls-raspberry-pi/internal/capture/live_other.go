@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+	"time"
+)
+
+// OpenLive is only implemented on Linux. Elsewhere (Windows/Npcap) the
+// tshark backend is still required for live capture.
+func OpenLive(iface string, duration time.Duration) (Source, error) {
+	return nil, errors.New("capture: live capture is not supported on this platform")
+}
@@ -0,0 +1,316 @@
+// Package capture reads 802.11 frames from pcap/pcapng files and from
+// live monitor-mode interfaces, without shelling out to tshark.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+type LinkType uint32
+
+// The only link types we care about. Anything else is handed back
+// to the caller, but Decode will refuse it.
+const (
+	LinkTypeIEEE80211 LinkType = 105
+	LinkTypeRadiotap  LinkType = 127
+)
+
+const (
+	pcapMagicMicro   = 0xa1b2c3d4
+	pcapMagicNano    = 0xa1b23c4d
+	pcapngSHB        = 0x0a0d0d0a
+	pcapngByteOrder  = 0x1a2b3c4d
+	pcapngIDB        = 0x00000001
+	pcapngSPB        = 0x00000003
+	pcapngEPB        = 0x00000006
+	pcapngTsresolOpt = 9
+	// Refuse to allocate anything bigger than this for one block.
+	maxBlockLength = 16 * 1024 * 1024
+)
+
+var ErrUnknownFormat = errors.New("capture: not a pcap or pcapng file")
+
+type Packet struct {
+	Timestamp time.Time
+	LinkType  LinkType
+	Data      []byte
+}
+
+// A Source hands back packets one at a time. Next returns io.EOF
+// when there is nothing more to read.
+type Source interface {
+	Next() (Packet, error)
+	Close() error
+}
+
+type fileSource struct {
+	Source
+	file *os.File
+}
+
+type packetReader interface {
+	next() (Packet, error)
+}
+
+// OpenFile opens a pcap or pcapng file. The format is sniffed from the
+// first four bytes of the file, so the extension does not matter.
+func OpenFile(path string) (Source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fileSource{Source: r, file: f}, nil
+}
+
+func (fs *fileSource) Close() error {
+	return fs.file.Close()
+}
+
+type readerSource struct {
+	reader packetReader
+}
+
+// NewReader wraps an io.Reader containing pcap or pcapng data.
+func NewReader(r io.Reader) (Source, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, ErrUnknownFormat
+	}
+	le := binary.LittleEndian.Uint32(magic)
+	be := binary.BigEndian.Uint32(magic)
+	switch {
+	case le == pcapMagicMicro || le == pcapMagicNano:
+		return newPcapReader(br, binary.LittleEndian)
+	case be == pcapMagicMicro || be == pcapMagicNano:
+		return newPcapReader(br, binary.BigEndian)
+	case le == pcapngSHB:
+		return &readerSource{reader: &pcapngReader{r: br}}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+func (rs *readerSource) Next() (Packet, error) {
+	return rs.reader.next()
+}
+
+func (rs *readerSource) Close() error {
+	return nil
+}
+
+////////////////////////////////////////////////////////
+// pcap
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType LinkType
+}
+
+func newPcapReader(r io.Reader, order binary.ByteOrder) (Source, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, ErrUnknownFormat
+	}
+	pr := &pcapReader{
+		r:        r,
+		order:    order,
+		nano:     order.Uint32(hdr[0:4]) == pcapMagicNano,
+		linkType: LinkType(order.Uint32(hdr[20:24]) & 0x0fffffff),
+	}
+	return &readerSource{reader: pr}, nil
+}
+
+func (pr *pcapReader) next() (Packet, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, io.EOF
+		}
+		return Packet{}, err
+	}
+	sec := int64(pr.order.Uint32(hdr[0:4]))
+	frac := int64(pr.order.Uint32(hdr[4:8]))
+	caplen := pr.order.Uint32(hdr[8:12])
+	if caplen > maxBlockLength {
+		return Packet{}, fmt.Errorf("capture: packet length %d too large", caplen)
+	}
+	if !pr.nano {
+		frac *= 1000
+	}
+	data := make([]byte, caplen)
+	if _, err := io.ReadFull(pr.r, data); err != nil {
+		return Packet{}, io.EOF
+	}
+	return Packet{
+		Timestamp: time.Unix(sec, frac),
+		LinkType:  pr.linkType,
+		Data:      data,
+	}, nil
+}
+
+////////////////////////////////////////////////////////
+// pcapng
+
+type pcapngInterface struct {
+	linkType LinkType
+	// Timestamp units per second.
+	resolution uint64
+}
+
+type pcapngReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+func (pr *pcapngReader) next() (Packet, error) {
+	for {
+		btype, body, err := pr.readBlock()
+		if err != nil {
+			return Packet{}, err
+		}
+		switch btype {
+		case pcapngSHB:
+			// A new section starts a new set of interfaces.
+			pr.interfaces = nil
+		case pcapngIDB:
+			if len(body) < 8 {
+				return Packet{}, errors.New("capture: short interface block")
+			}
+			iface := pcapngInterface{
+				linkType:   LinkType(pr.order.Uint16(body[0:2])),
+				resolution: 1000000,
+			}
+			pr.readOptions(body[8:], func(code uint16, value []byte) {
+				if code == pcapngTsresolOpt && len(value) > 0 {
+					iface.resolution = tsresol(value[0])
+				}
+			})
+			pr.interfaces = append(pr.interfaces, iface)
+		case pcapngEPB:
+			if len(body) < 20 {
+				return Packet{}, errors.New("capture: short packet block")
+			}
+			id := int(pr.order.Uint32(body[0:4]))
+			if id >= len(pr.interfaces) {
+				return Packet{}, fmt.Errorf("capture: unknown interface %d", id)
+			}
+			iface := pr.interfaces[id]
+			ts := uint64(pr.order.Uint32(body[4:8]))<<32 | uint64(pr.order.Uint32(body[8:12]))
+			caplen := int(pr.order.Uint32(body[12:16]))
+			if 20+caplen > len(body) {
+				return Packet{}, errors.New("capture: truncated packet block")
+			}
+			sec := ts / iface.resolution
+			nsec := (ts % iface.resolution) * 1000000000 / iface.resolution
+			return Packet{
+				Timestamp: time.Unix(int64(sec), int64(nsec)),
+				LinkType:  iface.linkType,
+				Data:      body[20 : 20+caplen],
+			}, nil
+		case pcapngSPB:
+			// Simple packet blocks carry no timestamp and always
+			// belong to the first interface.
+			if len(pr.interfaces) == 0 || len(body) < 4 {
+				continue
+			}
+			caplen := int(pr.order.Uint32(body[0:4]))
+			if 4+caplen > len(body) {
+				caplen = len(body) - 4
+			}
+			return Packet{
+				LinkType: pr.interfaces[0].linkType,
+				Data:     body[4 : 4+caplen],
+			}, nil
+		}
+		// Everything else (name resolution, statistics, ...) is skipped.
+	}
+}
+
+// readBlock returns the type and body of the next block, without the
+// leading type/length words or the trailing length word.
+func (pr *pcapngReader) readBlock() (uint32, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(pr.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) == pcapngSHB {
+		// The section header tells us the byte order of everything
+		// that follows, including its own length field.
+		bom := make([]byte, 4)
+		if _, err := io.ReadFull(pr.r, bom); err != nil {
+			return 0, nil, io.EOF
+		}
+		if binary.LittleEndian.Uint32(bom) == pcapngByteOrder {
+			pr.order = binary.LittleEndian
+		} else if binary.BigEndian.Uint32(bom) == pcapngByteOrder {
+			pr.order = binary.BigEndian
+		} else {
+			return 0, nil, ErrUnknownFormat
+		}
+		length := pr.order.Uint32(hdr[4:8])
+		if length < 16 || length > maxBlockLength {
+			return 0, nil, fmt.Errorf("capture: bad section length %d", length)
+		}
+		rest := make([]byte, length-12)
+		if _, err := io.ReadFull(pr.r, rest); err != nil {
+			return 0, nil, io.EOF
+		}
+		return pcapngSHB, append(bom, rest[:len(rest)-4]...), nil
+	}
+	if pr.order == nil {
+		return 0, nil, ErrUnknownFormat
+	}
+	btype := pr.order.Uint32(hdr[0:4])
+	length := pr.order.Uint32(hdr[4:8])
+	if length < 12 || length > maxBlockLength {
+		return 0, nil, fmt.Errorf("capture: bad block length %d", length)
+	}
+	rest := make([]byte, length-8)
+	if _, err := io.ReadFull(pr.r, rest); err != nil {
+		return 0, nil, io.EOF
+	}
+	return btype, rest[:len(rest)-4], nil
+}
+
+func (pr *pcapngReader) readOptions(opts []byte, fn func(uint16, []byte)) {
+	for len(opts) >= 4 {
+		code := pr.order.Uint16(opts[0:2])
+		length := int(pr.order.Uint16(opts[2:4]))
+		if code == 0 || 4+length > len(opts) {
+			return
+		}
+		fn(code, opts[4:4+length])
+		// Option values are padded to 32 bits.
+		padded := 4 + ((length + 3) &^ 3)
+		if padded > len(opts) {
+			return
+		}
+		opts = opts[padded:]
+	}
+}
+
+// tsresol decodes the if_tsresol option into units per second.
+func tsresol(v byte) uint64 {
+	if v&0x80 == 0 {
+		return uint64(math.Pow10(int(v)))
+	}
+	return uint64(1) << (v & 0x7f)
+}
@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func fixture(name string) string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "test", name)
+}
+
+func readAll(t *testing.T, path string) []Packet {
+	src, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	packets := make([]Packet, 0)
+	for {
+		p, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestReadPcap(t *testing.T) {
+	packets := readAll(t, fixture("capture.pcap"))
+	if len(packets) != 9 {
+		t.Fatal("expected 9 packets, got ", len(packets))
+	}
+	if packets[0].LinkType != LinkTypeRadiotap {
+		t.Error("wrong link type: ", packets[0].LinkType)
+	}
+	start := time.Date(2022, 5, 18, 10, 0, 0, 0, time.UTC)
+	if !packets[0].Timestamp.Equal(start) {
+		t.Error("wrong first timestamp: ", packets[0].Timestamp.UTC())
+	}
+	if !packets[8].Timestamp.Equal(start.Add(135 * time.Second)) {
+		t.Error("wrong last timestamp: ", packets[8].Timestamp.UTC())
+	}
+}
+
+func TestReadPcapng(t *testing.T) {
+	classic := readAll(t, fixture("capture.pcap"))
+	ng := readAll(t, fixture("capture.pcapng"))
+	if len(classic) != len(ng) {
+		t.Fatal("pcap and pcapng fixtures differ in length")
+	}
+	for i := range classic {
+		if !classic[i].Timestamp.Equal(ng[i].Timestamp) {
+			t.Error("timestamps differ at packet ", i)
+		}
+		if !bytes.Equal(classic[i].Data, ng[i].Data) {
+			t.Error("data differs at packet ", i)
+		}
+		if classic[i].LinkType != ng[i].LinkType {
+			t.Error("link types differ at packet ", i)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("this is not a capture")))
+	if err != ErrUnknownFormat {
+		t.Error("expected ErrUnknownFormat, got ", err)
+	}
+}
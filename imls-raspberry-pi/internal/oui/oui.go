@@ -0,0 +1,188 @@
+// Package oui maps MAC addresses to manufacturers, and manufacturers to
+// the coarse categories we report in `manufacturer_index`.
+package oui
+
+import (
+	"bufio"
+	"compress/gzip"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Manufacturer categories, as stored in `manufacturer_index`. The values
+// are part of the data we send, so only ever add to the end.
+const (
+	Unknown  = 0
+	Phone    = 1
+	Computer = 2
+	IoT      = 3
+	Network  = 4
+)
+
+var CategoryNames = map[int]string{
+	Unknown:  "unknown",
+	Phone:    "phone",
+	Computer: "computer",
+	IoT:      "iot",
+	Network:  "network",
+}
+
+// Words in a manufacturer's name that place it in a category. Most of
+// these companies make more than one kind of device; they are placed by
+// what we are most likely to see in a library. Only whole words match,
+// so "zte" is not found in "Aztech".
+var categoryWords = []struct {
+	word     string
+	category int
+}{
+	{"apple", Phone},
+	{"samsung", Phone},
+	{"google", Phone},
+	{"motorola", Phone},
+	{"oneplus", Phone},
+	{"huawei", Phone},
+	{"xiaomi", Phone},
+	{"oppo", Phone},
+	{"vivo mobile", Phone},
+	{"zte", Phone},
+	{"intel", Computer},
+	{"dell", Computer},
+	{"lenovo", Computer},
+	{"hewlett packard", Computer},
+	{"liteon", Computer},
+	{"lite on", Computer},
+	{"azurewave", Computer},
+	{"asustek", Computer},
+	{"microsoft", Computer},
+	{"espressif", IoT},
+	{"amazon", IoT},
+	{"roku", IoT},
+	{"sonos", IoT},
+	{"raspberry pi", IoT},
+	{"nest labs", IoT},
+	{"xerox", IoT},
+	{"cisco", Network},
+	{"meraki", Network},
+	{"aruba", Network},
+	{"ubiquiti", Network},
+	{"ruckus", Network},
+	{"netgear", Network},
+	{"tp-link", Network},
+}
+
+// words lowercases a name and puts a single space around each word,
+// so "TP-LINK Co.,Ltd" is " tp link co ltd ".
+func words(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(fields, " ") + " "
+}
+
+// Categorize places a manufacturer in one of the categories above. The
+// name it starts with wins ("Aruba, a Hewlett Packard Enterprise
+// Company" is Aruba); failing that, the first word found anywhere in it.
+func Categorize(manufacturer string) int {
+	name := words(manufacturer)
+	for _, cw := range categoryWords {
+		if strings.HasPrefix(name, words(cw.word)) {
+			return cw.category
+		}
+	}
+	for _, cw := range categoryWords {
+		if strings.Contains(name, words(cw.word)) {
+			return cw.category
+		}
+	}
+	return Unknown
+}
+
+// A Table maps 24-bit OUI prefixes ("F01898") to manufacturers.
+type Table struct {
+	manufacturers map[string]string
+}
+
+// The embedded file is the IEEE MA-L registry, from
+// https://standards-oui.ieee.org/oui/oui.csv, gzipped. `make registry`
+// brings it up to date. Point `oui.path` at a newer copy to use that
+// without a rebuild.
+//
+//go:embed oui.csv.gz
+var f embed.FS
+
+var ErrBadFormat = errors.New("oui: expected an IEEE MA-L CSV file")
+
+// Load reads a table in the IEEE CSV format, gzipped or not:
+// Registry,Assignment,Organization Name,Organization Address
+func Load(r io.Reader) (*Table, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) < 3 || rows[0][1] != "Assignment" {
+		return nil, ErrBadFormat
+	}
+	t := &Table{manufacturers: make(map[string]string)}
+	for _, row := range rows[1:] {
+		if len(row) < 3 || len(row[1]) != 6 {
+			continue
+		}
+		t.manufacturers[strings.ToUpper(row[1])] = strings.TrimSpace(row[2])
+	}
+	return t, nil
+}
+
+// Embedded returns the table compiled into the binary.
+func Embedded() *Table {
+	data, _ := f.Open("oui.csv.gz")
+	defer data.Close()
+	t, err := Load(data)
+	if err != nil {
+		// The embedded file is checked by the tests.
+		panic(err)
+	}
+	return t
+}
+
+func prefix(mac string) string {
+	return strings.ToUpper(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+}
+
+// Manufacturer looks up who a MAC address was assigned to. Randomized
+// (locally administered) addresses are not assigned to anyone.
+func (t *Table) Manufacturer(mac string) (string, bool) {
+	p := prefix(mac)
+	if len(p) < 6 {
+		return "", false
+	}
+	m, ok := t.manufacturers[p[:6]]
+	return m, ok
+}
+
+// Category is the manufacturer category of a MAC address.
+func (t *Table) Category(mac string) int {
+	m, ok := t.Manufacturer(mac)
+	if !ok {
+		return Unknown
+	}
+	return Categorize(m)
+}
+
+func (t *Table) Len() int {
+	return len(t.manufacturers)
+}
@@ -0,0 +1,82 @@
+package oui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func TestEmbedded(t *testing.T) {
+	table := Embedded()
+	if table.Len() == 0 {
+		t.Fatal("the embedded table is empty")
+	}
+	if m, ok := table.Manufacturer("f0:18:98:00:00:01"); !ok || m != "Apple, Inc." {
+		t.Error("expected Apple, got ", m)
+	}
+	for mac, expected := range map[string]int{
+		"f0:18:98:00:00:01": Phone,
+		"F0-18-98-00-00-01": Phone,
+		"b8:27:eb:00:00:04": IoT,
+		"00:18:0a:00:00:00": Network,
+		"3c:a9:f4:00:00:00": Computer,
+		"da:a1:19:00:00:01": Unknown,
+		"fp:1234":           Unknown,
+	} {
+		if c := table.Category(mac); c != expected {
+			t.Error(mac, ": expected ", CategoryNames[expected], " got ", CategoryNames[c])
+		}
+	}
+}
+
+func TestCategorizeWholeWords(t *testing.T) {
+	for name, expected := range map[string]int{
+		"Apple, Inc.":                                 Phone,
+		"Intel Corporate":                             Computer,
+		"TP-LINK TECHNOLOGIES CO.,LTD.":               Network,
+		"Lite-On Technology Corporation":              Computer,
+		"Hewlett-Packard Company":                     Computer,
+		"Aruba, a Hewlett Packard Enterprise Company": Network,
+		"Motorola Mobility LLC, a Lenovo Company":     Phone,
+		"Aztech Electronics Pte Ltd":                  Unknown,
+		"Intelbras":                                   Unknown,
+		"Intellian Technologies, Inc.":                Unknown,
+		"Pineapple Systems":                           Unknown,
+		"Shenzhen Rokunet Technology":                 Unknown,
+	} {
+		if c := Categorize(name); c != expected {
+			t.Error(name, ": expected ", CategoryNames[expected], " got ", CategoryNames[c])
+		}
+	}
+}
+
+func TestLoadGzipped(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("Registry,Assignment,Organization Name,Organization Address\n" +
+		"MA-L,00AABB,\"Example Devices, Inc.\",Somewhere\n"))
+	zw.Close()
+	table, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := table.Manufacturer("00:aa:bb:cc:dd:ee"); m != "Example Devices, Inc." {
+		t.Error("unexpected manufacturer ", m)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	table, err := Load(strings.NewReader(
+		"Registry,Assignment,Organization Name,Organization Address\n" +
+			"MA-L,00AABB,\"Example Devices, Inc.\",Somewhere\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := table.Manufacturer("00:aa:bb:cc:dd:ee"); m != "Example Devices, Inc." {
+		t.Error("unexpected manufacturer ", m)
+	}
+	if _, err := Load(strings.NewReader("prefix,name\n")); err != ErrBadFormat {
+		t.Error("expected a format error, got ", err)
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import "sync"
+
+type AdapterStat struct {
+	Sightings int
+	Devices   int
+}
+
+type adapterStat struct {
+	sightings int
+	devices   map[string]bool
+}
+
+// Per-adapter sighting counts for the current session, when capturing
+// on more than one adapter. Cleared alongside the ephemeral DB.
+var adapterStats = make(map[string]*adapterStat)
+
+// adapterStatsMu guards adapterStats.
+var adapterStatsMu sync.Mutex
+
+// NOTE: Do not log MAC addresses.
+func RecordAdapter(adapter string, mac string) {
+	adapterStatsMu.Lock()
+	defer adapterStatsMu.Unlock()
+	as, ok := adapterStats[adapter]
+	if !ok {
+		as = &adapterStat{devices: make(map[string]bool)}
+		adapterStats[adapter] = as
+	}
+	as.sightings += 1
+	as.devices[mac] = true
+}
+
+func GetAdapterStats() map[string]AdapterStat {
+	adapterStatsMu.Lock()
+	defer adapterStatsMu.Unlock()
+	stats := make(map[string]AdapterStat)
+	for adapter, as := range adapterStats {
+		stats[adapter] = AdapterStat{Sightings: as.sightings, Devices: len(as.devices)}
+	}
+	return stats
+}
+
+func clearAdapterStats() {
+	adapterStatsMu.Lock()
+	defer adapterStatsMu.Unlock()
+	adapterStats = make(map[string]*adapterStat)
+}
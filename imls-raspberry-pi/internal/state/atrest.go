@@ -0,0 +1,208 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"gsa.gov/18f/internal/cryptopasta"
+)
+
+// Encrypted at rest, a database at `path` is kept as `path` + SealedExt.
+// SQLite needs a plain file to work on, so while the counter runs it works
+// on a decrypted copy in `storage.workdir`, which should be on a tmpfs
+// (/run is, on the Pi), and seals it back at every reset and on shutdown.
+const SealedExt = ".enc"
+
+var ErrSecretInWWW = errors.New("the storage secret must be kept outside the web root")
+
+// The label mixed into the device secret to get the storage key, so the
+// secret could be put to other uses without giving this key away.
+const storageKeyLabel = "session-counter storage at rest"
+
+var storageKey *[32]byte
+
+func IsEncryptingAtRest() bool {
+	return viper.GetBool("storage.encrypt")
+}
+
+func SetEncryptingAtRest(encrypt bool) {
+	viper.Set("storage.encrypt", encrypt)
+	storageKey = nil
+}
+
+func GetStorageSecretPath() string {
+	return viper.GetString("storage.secret")
+}
+
+func SetStorageSecretPath(path string) {
+	viper.Set("storage.secret", path)
+	storageKey = nil
+}
+
+func GetStorageWorkdir() string {
+	return viper.GetString("storage.workdir")
+}
+
+func SetStorageWorkdir(path string) {
+	viper.Set("storage.workdir", path)
+}
+
+func insideDir(path string, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// StorageKey derives the at-rest key from the device secret at
+// `storage.secret`, creating the secret the first time. The secret is
+// never kept under the web root.
+func StorageKey() (*[32]byte, error) {
+	if storageKey != nil {
+		return storageKey, nil
+	}
+	path := GetStorageSecretPath()
+	if insideDir(path, GetWWWRoot()) {
+		return nil, ErrSecretInWWW
+	}
+	secret, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret = []byte(hex.EncodeToString(cryptopasta.NewEncryptionKey()[:]))
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = ioutil.WriteFile(path, secret, 0600)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage secret %s: %w", path, err)
+	}
+	storageKey = deriveStorageKey(secret)
+	return storageKey, nil
+}
+
+func deriveStorageKey(secret []byte) *[32]byte {
+	h := hmac.New(sha256.New, []byte(strings.TrimSpace(string(secret))))
+	h.Write([]byte(storageKeyLabel))
+	key := [32]byte{}
+	copy(key[:], h.Sum(nil))
+	return &key
+}
+
+// workingPath is where the decrypted copy of the database at `path`
+// lives while it is open.
+func workingPath(path string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(path)))
+	return filepath.Join(GetStorageWorkdir(),
+		fmt.Sprintf("%x-%s", sum[:4], filepath.Base(path)))
+}
+
+// writeAtomically replaces `path` without ever leaving a partial file.
+func writeAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// unseal decrypts the database at `path` into its working copy. A
+// plaintext database left over from before encryption was turned on is
+// picked up as it is, and removed the first time it is sealed. So is a
+// working copy newer than the sealed one: left by a crash, or by a seal
+// that failed.
+func unseal(path string, working string) error {
+	key, err := StorageKey()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(working), 0700); err != nil {
+		return err
+	}
+	if w, err := os.Stat(working); err == nil {
+		if s, err := os.Stat(path + SealedExt); err == nil && w.ModTime().After(s.ModTime()) {
+			return nil
+		}
+	}
+	plaintext, err := ioutil.ReadFile(path + SealedExt)
+	if err == nil {
+		plaintext, err = cryptopasta.Decrypt(plaintext, key)
+		if err != nil {
+			return fmt.Errorf("could not decrypt %s: %w", path+SealedExt, err)
+		}
+	} else if os.IsNotExist(err) {
+		plaintext, err = ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// A new database.
+			os.Remove(working)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return writeAtomically(working, plaintext)
+}
+
+// seal encrypts a plain copy of the database back to `path` + SealedExt
+// and removes any plaintext database at `path`.
+func seal(path string, working string) error {
+	key, err := StorageKey()
+	if err != nil {
+		return err
+	}
+	plaintext, err := ioutil.ReadFile(working)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ciphertext, err := cryptopasta.Encrypt(plaintext, key)
+	if err != nil {
+		return err
+	}
+	if err := writeAtomically(path+SealedExt, ciphertext); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DecryptFile decrypts a sealed database to `out`, for export and
+// support, using the device secret at `secretPath`.
+func DecryptFile(in string, out string, secretPath string) error {
+	secret, err := ioutil.ReadFile(secretPath)
+	if err != nil {
+		return fmt.Errorf("storage secret %s: %w", secretPath, err)
+	}
+	ciphertext, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	plaintext, err := cryptopasta.Decrypt(ciphertext, deriveStorageKey(secret))
+	if err != nil {
+		return fmt.Errorf("could not decrypt %s: %w", in, err)
+	}
+	return ioutil.WriteFile(out, plaintext, 0600)
+}
+
+// SealDatabases seals every open database that is encrypted at rest.
+// It runs at every reset, every `cron.seal`, and on shutdown.
+func SealDatabases() {
+	for _, db := range cachedDBs() {
+		if err := db.Seal(); err != nil {
+			log.Error().
+				Err(err).
+				Str("path", db.Path).
+				Msg("could not seal database")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type Pear struct {
+	Variety string `db:"variety" type:"TEXT"`
+}
+
+func atRestSetup(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "atrest-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	FlushCache()
+	SetRootPath(filepath.Join(dir, "www"))
+	SetStorageSecretPath(filepath.Join(dir, "etc", "storage.secret"))
+	SetStorageWorkdir(filepath.Join(dir, "run"))
+	SetEncryptingAtRest(true)
+	os.Mkdir(GetWWWRoot(), 0755)
+	return dir
+}
+
+func atRestTeardown(dir string) {
+	FlushCache()
+	SetEncryptingAtRest(false)
+	os.RemoveAll(dir)
+}
+
+func pears(t *testing.T, db *SqliteDB) []Pear {
+	found := []Pear{}
+	if err := db.GetPtr().Select(&found, "SELECT * FROM pears"); err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestSealedDatabase(t *testing.T) {
+	dir := atRestSetup(t)
+	defer atRestTeardown(dir)
+	path := filepath.Join(GetWWWRoot(), "durations.sqlite")
+
+	db := NewSqliteDB(path)
+	db.CreateTableFromStruct(Pear{})
+	db.GetTableFromStruct(Pear{}).InsertStruct(Pear{Variety: "bosc"})
+	if err := db.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("nothing should be written in the clear under the web root")
+	}
+	sealed, err := ioutil.ReadFile(path + SealedExt)
+	if err != nil || bytes.Contains(sealed, []byte("bosc")) || bytes.Contains(sealed, []byte("SQLite")) {
+		t.Error("expected an encrypted copy of the database ", err)
+	}
+
+	// Closing seals too, and leaves nothing decrypted behind.
+	db.GetTableFromStruct(Pear{}).InsertStruct(Pear{Variety: "anjou"})
+	FlushCache()
+	if entries, _ := ioutil.ReadDir(GetStorageWorkdir()); len(entries) != 0 {
+		t.Error("the working copy should be removed on close")
+	}
+	if found := pears(t, NewSqliteDB(path)); len(found) != 2 {
+		t.Error("expected the database to reopen with both rows, found ", found)
+	}
+
+	out := filepath.Join(dir, "export.sqlite")
+	if err := DecryptFile(path+SealedExt, out, GetStorageSecretPath()); err != nil {
+		t.Fatal(err)
+	}
+	plain := &SqliteDB{Path: out, file: out, Tables: make(map[string]*SqliteTable)}
+	plain.Open()
+	defer plain.Ptr.Close()
+	if found := pears(t, plain); len(found) != 2 {
+		t.Error("expected the decrypted export to have both rows, found ", found)
+	}
+
+	other := filepath.Join(dir, "other.secret")
+	ioutil.WriteFile(other, []byte("not the device secret"), 0600)
+	if err := DecryptFile(path+SealedExt, out, other); err == nil {
+		t.Error("expected the wrong secret to fail")
+	}
+}
+
+func TestSealFailureKeepsWorkingCopy(t *testing.T) {
+	dir := atRestSetup(t)
+	defer atRestTeardown(dir)
+	path := filepath.Join(GetWWWRoot(), "durations.sqlite")
+
+	db := NewSqliteDB(path)
+	db.CreateTableFromStruct(Pear{})
+	db.GetTableFromStruct(Pear{}).InsertStruct(Pear{Variety: "bosc"})
+	if err := db.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	db.GetTableFromStruct(Pear{}).InsertStruct(Pear{Variety: "comice"})
+	// Something is in the way of the sealed copy, so sealing fails.
+	sealed, _ := ioutil.ReadFile(path + SealedExt)
+	os.Remove(path + SealedExt)
+	os.Mkdir(path+SealedExt, 0755)
+	db.Close()
+	if _, err := os.Stat(db.file); err != nil {
+		t.Fatal("the working copy should be kept when it cannot be sealed ", err)
+	}
+
+	// Once the sealed copy is back, the newer working copy is picked up.
+	os.Remove(path + SealedExt)
+	ioutil.WriteFile(path+SealedExt, sealed, 0600)
+	os.Chtimes(db.file, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	db = NewSqliteDB(path)
+	if found := pears(t, db); len(found) != 2 {
+		t.Error("expected the working copy, with both rows, found ", found)
+	}
+}
+
+func TestSealPlaintextDatabase(t *testing.T) {
+	dir := atRestSetup(t)
+	defer atRestTeardown(dir)
+	path := filepath.Join(GetWWWRoot(), "queues.sqlite")
+
+	SetEncryptingAtRest(false)
+	db := NewSqliteDB(path)
+	db.CreateTableFromStruct(Pear{})
+	db.GetTableFromStruct(Pear{}).InsertStruct(Pear{Variety: "bartlett"})
+	FlushCache()
+
+	// Turning encryption on picks up the plaintext database and seals it.
+	SetEncryptingAtRest(true)
+	db = NewSqliteDB(path)
+	if found := pears(t, db); len(found) != 1 {
+		t.Fatal("expected the existing rows, found ", found)
+	}
+	SealDatabases()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("the plaintext database should be removed once sealed")
+	}
+}
+
+func TestStorageSecretOutsideWWW(t *testing.T) {
+	dir := atRestSetup(t)
+	defer atRestTeardown(dir)
+	SetStorageSecretPath(filepath.Join(GetWWWRoot(), "storage.secret"))
+	if _, err := StorageKey(); err != ErrSecretInWWW {
+		t.Error("expected a secret under the web root to be refused, got ", err)
+	}
+}
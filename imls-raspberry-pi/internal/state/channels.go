@@ -0,0 +1,50 @@
+package state
+
+import "sync"
+
+type ChannelStat struct {
+	Sightings int
+	Devices   int
+}
+
+type channelStat struct {
+	sightings int
+	devices   map[string]bool
+}
+
+// Per-channel sighting counts for the current session. Cleared alongside
+// the ephemeral DB.
+var channelStats = make(map[int]*channelStat)
+
+// channelMu guards channelStats; each capture records from its own
+// goroutine.
+var channelMu sync.Mutex
+
+// NOTE: Do not log MAC addresses.
+func RecordChannel(channel int, mac string) {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	cs, ok := channelStats[channel]
+	if !ok {
+		cs = &channelStat{devices: make(map[string]bool)}
+		channelStats[channel] = cs
+	}
+	cs.sightings += 1
+	cs.devices[mac] = true
+}
+
+func GetChannelStats() map[int]ChannelStat {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	stats := make(map[int]ChannelStat)
+	for channel, cs := range channelStats {
+		stats[channel] = ChannelStat{Sightings: cs.sightings, Devices: len(cs.devices)}
+	}
+	return stats
+}
+
+func clearChannelStats() {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	channelStats = make(map[int]*channelStat)
+}
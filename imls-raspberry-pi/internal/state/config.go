@@ -2,17 +2,25 @@ package state
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"gsa.gov/18f/internal/interfaces"
 	"gsa.gov/18f/internal/structs"
+	"gsa.gov/18f/internal/zero-log-sentry"
 )
 
 func SetConfigAtPath(configPath string) {
+	// Nothing that looks like a MAC address gets to stderr. (Sentry, if
+	// it is set up, redacts the same way.)
+	log.Logger = zerolog.New(zls.NewRedactor(os.Stderr)).With().Timestamp().Logger()
 	SetConfigDefaults()
 	viper.AddConfigPath(".")
 	if runtime.GOOS == "linux" {
@@ -29,7 +37,12 @@ func SetConfigAtPath(configPath string) {
 	err := viper.ReadInConfig()
 	if err != nil {
 		log.Info().Msg("no configuration found: writing")
-		viper.SafeWriteConfig()
+		// Where we were told to look, not the working directory.
+		if configPath != "" {
+			viper.SafeWriteConfigAs(configPath)
+		} else {
+			viper.SafeWriteConfig()
+		}
 	}
 	log.Info().Msg(fmt.Sprintf("using configuration: %s", viper.ConfigFileUsed()))
 	// configure logging.
@@ -120,6 +133,11 @@ func GetLoggers() []string {
 	return strings.Split(loggers, ",")
 }
 
+// GetLogPath is where the local loggers keep their files.
+func GetLogPath() string {
+	return viper.GetString("log.path")
+}
+
 func GetDurationsURI() string {
 	scheme := viper.GetString("api.scheme")
 	host := viper.GetString("api.host")
@@ -129,6 +147,25 @@ func GetDurationsURI() string {
 		startsWithSlash(removeLeadingSlashes(path)))
 }
 
+// GetCoverageURI is where each session's coverage summary is sent.
+func GetCoverageURI() string {
+	scheme := viper.GetString("api.scheme")
+	host := viper.GetString("api.host")
+	path := viper.GetString("api.coverage_uri")
+	return (scheme + "://" +
+		removeLeadingAndTrailingSlashes(host) +
+		startsWithSlash(removeLeadingSlashes(path)))
+}
+
+func GetAggregatesURI() string {
+	scheme := viper.GetString("api.scheme")
+	host := viper.GetString("api.host")
+	path := viper.GetString("api.aggregates_uri")
+	return (scheme + "://" +
+		removeLeadingAndTrailingSlashes(host) +
+		startsWithSlash(removeLeadingSlashes(path)))
+}
+
 func IsStoringToAPI() bool {
 	mode := viper.GetString("mode.storage")
 	return strings.Contains(strings.ToLower(mode), "api")
@@ -172,13 +209,25 @@ func GetDurationsPath() string {
 func GetDurationsDatabase() interfaces.Database {
 	path := viper.GetString("db.durations")
 	// always make sure we have a durations db created
+	// (and up to date, if an older version created it).
 	db := NewSqliteDB(path)
-	if !db.CheckTableExists("durations") {
-		db.CreateTableFromStruct(structs.Duration{})
-	}
+	db.CreateTableFromStruct(structs.Duration{})
+	db.CreateTableFromStruct(structs.ChannelCount{})
+	db.CreateTableFromStruct(structs.AdapterCount{})
+	db.CreateTableFromStruct(structs.ScanStat{})
+	db.CreateTableFromStruct(structs.Coverage{})
+	db.CreateTableFromStruct(structs.NetworkTotal{})
+	db.CreateTableFromStruct(structs.Aggregate{})
+	db.CreateTableFromStruct(structs.PrivacySpend{})
+	db.CreateTableFromStruct(structs.Upload{})
+	db.CreateTableFromStruct(structs.Purge{})
 	return db
 }
 
+func GetQueuesPath() string {
+	return viper.GetString("db.queues")
+}
+
 func GetQueuesDatabase() interfaces.Database {
 	path := viper.GetString("db.queues")
 	return NewSqliteDB(path)
@@ -192,6 +241,392 @@ func GetWiresharkDuration() int {
 	return viper.GetInt("wireshark.duration")
 }
 
+// GetCaptureBackend says which SharkFn to use: "tshark" (the default)
+// or "native" for the pure-Go capture package.
+func GetCaptureBackend() string {
+	return strings.ToLower(viper.GetString("capture.backend"))
+}
+
+func SetCaptureBackend(backend string) {
+	viper.Set("capture.backend", backend)
+}
+
+// GetCaptureMode is "burst" (a `wireshark.duration` capture once a
+// minute) or "stream" (one long-running capture, restarted if it dies).
+func GetCaptureMode() string {
+	return strings.ToLower(viper.GetString("capture.mode"))
+}
+
+func SetCaptureMode(mode string) {
+	viper.Set("capture.mode", mode)
+}
+
+// GetCaptureAdapters is how many matching adapters to capture on at
+// once. Zero means every one we find.
+func GetCaptureAdapters() int {
+	return viper.GetInt("capture.adapters")
+}
+
+func SetCaptureAdapters(n int) {
+	viper.Set("capture.adapters", n)
+}
+
+// GetFrameClasses returns the frame classes that count as sightings, from
+// `capture.frames`, or nil for "all".
+func GetFrameClasses() []string {
+	classes := make([]string, 0)
+	for _, c := range strings.Split(viper.GetString("capture.frames"), ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch c {
+		case "all", "":
+			return nil
+		case structs.FrameProbe, structs.FrameAssoc, structs.FrameData, structs.FrameOther:
+			classes = append(classes, c)
+		default:
+			log.Warn().
+				Str("class", c).
+				Msg("ignoring unknown frame class in capture.frames")
+		}
+	}
+	if len(classes) == 0 {
+		return nil
+	}
+	return classes
+}
+
+func SetFrameClasses(classes string) {
+	viper.Set("capture.frames", classes)
+}
+
+// GetRandomizedPolicy says what to do with short-lived randomized MACs:
+// "count" them like any other device, "discount" them, or "collapse"
+// back-to-back ones into a single device.
+func GetRandomizedPolicy() string {
+	policy := strings.ToLower(viper.GetString("randomized.policy"))
+	switch policy {
+	case "count", "discount", "collapse":
+		return policy
+	}
+	log.Warn().
+		Str("policy", policy).
+		Msg("unknown randomized.policy; counting randomized MACs")
+	return "count"
+}
+
+func SetRandomizedPolicy(policy string) {
+	viper.Set("randomized.policy", policy)
+}
+
+// GetRandomizedShortMinutes is how long a randomized MAC can be seen and
+// still be considered short-lived.
+func GetRandomizedShortMinutes() int {
+	return viper.GetInt("randomized.short_minutes")
+}
+
+// GetRandomizedCollapseGap is the longest gap, in minutes, between one
+// randomized MAC going quiet and the next appearing for the two to be
+// collapsed into one device.
+func GetRandomizedCollapseGap() int {
+	return viper.GetInt("randomized.collapse_gap")
+}
+
+func SetRandomizedCollapseGap(minutes int) {
+	viper.Set("randomized.collapse_gap", minutes)
+}
+
+// GetFingerprinting says whether to group randomized MACs by a hash of
+// the information elements in their probe requests.
+func GetFingerprinting() bool {
+	return viper.GetBool("fingerprint.enabled")
+}
+
+func SetFingerprinting(enabled bool) {
+	viper.Set("fingerprint.enabled", enabled)
+}
+
+// GetOUIPath is an IEEE OUI CSV to use in place of the one compiled in.
+func GetOUIPath() string {
+	return viper.GetString("oui.path")
+}
+
+func SetOUIPath(path string) {
+	viper.Set("oui.path", path)
+}
+
+// GetNetworkMode is "all" to report every device we see, or "library"
+// to report only the devices using the library's own network.
+func GetNetworkMode() string {
+	mode := strings.ToLower(viper.GetString("network.mode"))
+	switch mode {
+	case "all", "library":
+		return mode
+	}
+	log.Warn().
+		Str("mode", mode).
+		Msg("unknown network.mode; reporting all devices")
+	return "all"
+}
+
+func SetNetworkMode(mode string) {
+	viper.Set("network.mode", mode)
+}
+
+// GetPrivacyMode is "off" to upload durations as they are, or "dp" to
+// upload only counts with differential-privacy noise added.
+func GetPrivacyMode() string {
+	mode := strings.ToLower(viper.GetString("privacy.mode"))
+	switch mode {
+	case "off", "dp":
+		return mode
+	}
+	// Fail closed: a typo should not upload the durations.
+	log.Warn().
+		Str("mode", mode).
+		Msg("unknown privacy.mode; adding noise")
+	return "dp"
+}
+
+func SetPrivacyMode(mode string) {
+	viper.Set("privacy.mode", mode)
+}
+
+// GetPrivacyMechanism is "laplace" or "gaussian". Gaussian noise is only
+// published with a `privacy.epsilon` below 2, as each statistic gets
+// half of it and the calibration needs less than 1.
+func GetPrivacyMechanism() string {
+	mechanism := strings.ToLower(viper.GetString("privacy.mechanism"))
+	switch mechanism {
+	case "laplace", "gaussian":
+		return mechanism
+	}
+	log.Warn().
+		Str("mechanism", mechanism).
+		Msg("unknown privacy.mechanism; using laplace")
+	return "laplace"
+}
+
+func SetPrivacyMechanism(mechanism string) {
+	viper.Set("privacy.mechanism", mechanism)
+}
+
+// GetPrivacyEpsilon is the budget each device gets per session, split
+// across everything published about the session.
+func GetPrivacyEpsilon() float64 {
+	return viper.GetFloat64("privacy.epsilon")
+}
+
+func SetPrivacyEpsilon(epsilon float64) {
+	viper.Set("privacy.epsilon", epsilon)
+}
+
+// GetPrivacyDelta is only used by the Gaussian mechanism.
+func GetPrivacyDelta() float64 {
+	return viper.GetFloat64("privacy.delta")
+}
+
+func SetPrivacyDelta(delta float64) {
+	viper.Set("privacy.delta", delta)
+}
+
+// GetPrivacyMaxHours bounds how many hourly counts one device can be in,
+// which is what the hourly noise is scaled to. A device seen for longer
+// only counts towards its first `privacy.max_hours` hours.
+func GetPrivacyMaxHours() int {
+	return viper.GetInt("privacy.max_hours")
+}
+
+func SetPrivacyMaxHours(hours int) {
+	viper.Set("privacy.max_hours", hours)
+}
+
+// GetPrivacyMaxVisits bounds how many visits by one device are counted.
+// A device that leaves for more than two hours comes back as a new visit.
+func GetPrivacyMaxVisits() int {
+	return viper.GetInt("privacy.max_visits")
+}
+
+func SetPrivacyMaxVisits(visits int) {
+	viper.Set("privacy.max_visits", visits)
+}
+
+// GetPrivacyK is the fewest devices an hour of arrivals may report;
+// 0 or 1 turns small-count protection off.
+func GetPrivacyK() int {
+	return viper.GetInt("privacy.k")
+}
+
+func SetPrivacyK(k int) {
+	viper.Set("privacy.k", k)
+}
+
+// GetPrivacySmallBuckets is "merge" to fold hours with fewer than
+// `privacy.k` devices into their neighbours, or "suppress" to drop them.
+func GetPrivacySmallBuckets() string {
+	action := strings.ToLower(viper.GetString("privacy.small_buckets"))
+	switch action {
+	case "merge", "suppress":
+		return action
+	}
+	log.Warn().
+		Str("small_buckets", action).
+		Msg("unknown privacy.small_buckets; merging")
+	return "merge"
+}
+
+func SetPrivacySmallBuckets(action string) {
+	viper.Set("privacy.small_buckets", action)
+}
+
+// GetPrivacyGranularity is the resolution, in minutes, that duration
+// start and end times are stored and uploaded at; 0 keeps them exact. It
+// should divide an hour.
+func GetPrivacyGranularity() int {
+	return viper.GetInt("privacy.granularity")
+}
+
+func SetPrivacyGranularity(minutes int) {
+	viper.Set("privacy.granularity", minutes)
+}
+
+// commaList splits a comma-separated config value, dropping blanks.
+func commaList(key string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(viper.GetString(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// GetLibraryBSSIDs returns the library's access points, from
+// `network.bssids`, in lowercase.
+func GetLibraryBSSIDs() []string {
+	bssids := commaList("network.bssids")
+	for i, b := range bssids {
+		bssids[i] = strings.ToLower(b)
+	}
+	return bssids
+}
+
+func SetLibraryBSSIDs(bssids string) {
+	viper.Set("network.bssids", bssids)
+}
+
+// GetLibrarySSIDs returns the library's network names, from
+// `network.ssids`. Their BSSIDs are learned from beacons. Anyone can name
+// a network "Library" (a phone's hotspot, or an access point set up to
+// catch the library's users), so when `network.bssids` is set, only
+// BSSIDs from the same manufacturer as one of those are learned; when it
+// is not, anything beaconing the name counts until the reset. Set
+// `network.bssids` where that matters.
+func GetLibrarySSIDs() []string {
+	return commaList("network.ssids")
+}
+
+func SetLibrarySSIDs(ssids string) {
+	viper.Set("network.ssids", ssids)
+}
+
+// Channels we hop across when `channels.plan` is empty.
+var defaultChannels = map[string][]int{
+	"2.4": {1, 6, 11},
+	"5":   {36, 40, 44, 48, 149, 153, 157, 161, 165},
+}
+
+func channelBand(channel int) string {
+	if channel <= 14 {
+		return "2.4"
+	}
+	return "5"
+}
+
+// GetChannelPlan returns the channels to hop across, in order, or nil if
+// hopping is off. Channels outside the configured `channels.bands` are
+// dropped from the plan.
+func GetChannelPlan() []int {
+	if !viper.GetBool("channels.hop") {
+		return nil
+	}
+	bands := make([]string, 0)
+	for _, b := range strings.Split(viper.GetString("channels.bands"), ",") {
+		b = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(b), "ghz"))
+		if _, ok := defaultChannels[b]; ok {
+			bands = append(bands, b)
+		}
+	}
+	inBands := func(channel int) bool {
+		for _, b := range bands {
+			if channelBand(channel) == b {
+				return true
+			}
+		}
+		return false
+	}
+
+	plan := make([]int, 0)
+	if strings.TrimSpace(viper.GetString("channels.plan")) == "" {
+		for _, b := range bands {
+			plan = append(plan, defaultChannels[b]...)
+		}
+		return plan
+	}
+	for _, c := range strings.Split(viper.GetString("channels.plan"), ",") {
+		channel, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || channel <= 0 {
+			log.Warn().
+				Str("channel", c).
+				Msg("ignoring bad channel in channels.plan")
+			continue
+		}
+		if inBands(channel) {
+			plan = append(plan, channel)
+		}
+	}
+	return plan
+}
+
+// GetChannelDwell is how long to stay on each channel when hopping.
+func GetChannelDwell() time.Duration {
+	return time.Duration(viper.GetInt("channels.dwell")) * time.Second
+}
+
+func SetChannelDwell(seconds int) {
+	viper.Set("channels.dwell", seconds)
+}
+
+func SetChannelHopping(hop bool) {
+	viper.Set("channels.hop", hop)
+}
+
+func SetChannelPlan(plan string) {
+	viper.Set("channels.plan", plan)
+}
+
+func SetChannelBands(bands string) {
+	viper.Set("channels.bands", bands)
+}
+
+// GetSignalFloor is the weakest signal, in dBm, that counts as a
+// sighting. Zero turns the floor off.
+func GetSignalFloor() int {
+	return viper.GetInt("signal.floor")
+}
+
+func SetSignalFloor(dbm int) {
+	viper.Set("signal.floor", dbm)
+}
+
+// GetSignalMinMedian is the weakest median signal, in dBm, a device can
+// have and still be counted. Zero turns the rule off.
+func GetSignalMinMedian() int {
+	return viper.GetInt("signal.min_median")
+}
+
+func SetSignalMinMedian(dbm int) {
+	viper.Set("signal.min_median", dbm)
+}
+
 func GetIpPath() string {
 	return viper.GetString("ip.path")
 }
@@ -224,6 +659,65 @@ func GetResetCron() string {
 	return viper.GetString("cron.reset")
 }
 
+func SetResetCron(crontab string) {
+	viper.Set("cron.reset", crontab)
+}
+
+// GetSealCron is when the databases encrypted at rest are sealed, on top
+// of every reset, so a crash loses no more than the time in between.
+func GetSealCron() string {
+	return viper.GetString("cron.seal")
+}
+
+// GetResetBoundary says what a reset does with visits still going on: a
+// device seen within the memory window. "close" reports them as they
+// stand; "split" reports them, and starts them afresh in the next
+// session; "carry" reports them in the next session instead, whole.
+// Either way, a visit a reset cut short is marked truncated.
+func GetResetBoundary() string {
+	return viper.GetString("reset.boundary")
+}
+
+func SetResetBoundary(boundary string) {
+	viper.Set("reset.boundary", boundary)
+}
+
+// GetPurgeCron is when old, uploaded data is purged under the retention
+// settings. It should not be when the reset runs.
+func GetPurgeCron() string {
+	return viper.GetString("cron.purge")
+}
+
+// The retention settings are how many days after a session is uploaded
+// its durations (with its coverage, aggregates, and network, channel and
+// adapter counts), its scan stats, and images are kept. 0 keeps them forever.
+// Durations, coverage and aggregates count from when they themselves
+// were uploaded; under `privacy.mode` dp the durations never are, and
+// stay.
+func GetRetentionDurations() int {
+	return viper.GetInt("retention.durations")
+}
+
+func SetRetentionDurations(days int) {
+	viper.Set("retention.durations", days)
+}
+
+func GetRetentionScanStats() int {
+	return viper.GetInt("retention.scanstats")
+}
+
+func SetRetentionScanStats(days int) {
+	viper.Set("retention.scanstats", days)
+}
+
+func GetRetentionImages() int {
+	return viper.GetInt("retention.images")
+}
+
+func SetRetentionImages(days int) {
+	viper.Set("retention.images", days)
+}
+
 func GetWWWRoot() string {
 	return viper.GetString("www.root")
 }
@@ -248,9 +742,53 @@ func SetConfigDefaults() {
 	viper.SetDefault("api.scheme", "https")
 	viper.SetDefault("api.host", "rabbit-phase-4.app.cloud.gov")
 	viper.SetDefault("api.uri", "/items/durations_v2/")
+	viper.SetDefault("api.coverage_uri", "/items/coverage/")
+	viper.SetDefault("api.aggregates_uri", "/items/aggregates/")
 	viper.SetDefault("cron.reset", "0 0 * * *")
+	viper.SetDefault("cron.purge", "30 0 * * *")
+	viper.SetDefault("cron.seal", "*/15 * * * *")
+	viper.SetDefault("reset.boundary", "close")
 	viper.SetDefault("wireshark.duration", 45)
+	viper.SetDefault("capture.backend", "tshark")
+	viper.SetDefault("capture.mode", "burst")
+	viper.SetDefault("capture.frames", "all")
+	viper.SetDefault("capture.adapters", 1)
+	viper.SetDefault("randomized.policy", "count")
+	viper.SetDefault("randomized.short_minutes", 5)
+	viper.SetDefault("randomized.collapse_gap", 2)
+	viper.SetDefault("fingerprint.enabled", false)
+	viper.SetDefault("oui.path", "")
+	viper.SetDefault("network.mode", "all")
+	viper.SetDefault("network.bssids", "")
+	viper.SetDefault("network.ssids", "")
+	viper.SetDefault("exclude.key", "")
+	viper.SetDefault("exclude.list", "")
+	viper.SetDefault("channels.hop", false)
+	viper.SetDefault("channels.plan", "")
+	viper.SetDefault("channels.bands", "2.4,5")
+	viper.SetDefault("channels.dwell", 5)
+	viper.SetDefault("signal.floor", 0)
+	viper.SetDefault("signal.min_median", 0)
+	viper.SetDefault("privacy.mode", "off")
+	viper.SetDefault("privacy.mechanism", "laplace")
+	viper.SetDefault("privacy.epsilon", 1.0)
+	viper.SetDefault("privacy.delta", 1e-6)
+	viper.SetDefault("privacy.max_hours", 12)
+	viper.SetDefault("privacy.max_visits", 2)
+	viper.SetDefault("privacy.k", 0)
+	viper.SetDefault("privacy.small_buckets", "merge")
+	viper.SetDefault("privacy.granularity", 0)
+	viper.SetDefault("retention.durations", 0)
+	viper.SetDefault("retention.scanstats", 0)
+	viper.SetDefault("retention.images", 0)
+	viper.SetDefault("ephemeral.store", "memory")
+	viper.SetDefault("storage.encrypt", false)
 	if runtime.GOOS == "windows" {
+		viper.SetDefault("ephemeral.path", "c:/ProgramData/imls/ephemeral.sqlite")
+		viper.SetDefault("storage.secret", "c:/ProgramData/imls/storage.secret")
+		viper.SetDefault("exclude.keyfile", "c:/ProgramData/imls/exclude.key")
+		viper.SetDefault("storage.workdir", filepath.Join(os.TempDir(), "imls"))
+		viper.SetDefault("log.path", "c:/ProgramData/imls/logs")
 		viper.SetDefault("wireshark.path", "c:/Program Files/Wireshark/tshark.exe")
 		viper.SetDefault("wlanhelper.path", "c:/Windows/System32/Npcap/WlanHelper.exe")
 		viper.SetDefault("www.root", "c:/imls")
@@ -258,6 +796,11 @@ func SetConfigDefaults() {
 		viper.SetDefault("db.durations", "c:/imls/durations.sqlite")
 		viper.SetDefault("db.queues", "c:/imls/queues.sqlite")
 	} else {
+		viper.SetDefault("ephemeral.path", "/var/lib/imls/ephemeral.sqlite")
+		viper.SetDefault("storage.secret", "/etc/imls/storage.secret")
+		viper.SetDefault("exclude.keyfile", "/etc/imls/exclude.key")
+		viper.SetDefault("storage.workdir", "/run/imls")
+		viper.SetDefault("log.path", "/var/log/imls")
 		viper.SetDefault("iw.path", "/usr/sbin/iw")
 		viper.SetDefault("ip.path", "/usr/sbin/ip")
 		viper.SetDefault("wireshark.path", "/usr/bin/tshark")
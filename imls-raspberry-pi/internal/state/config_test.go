@@ -14,11 +14,7 @@ type ConfigSuite struct {
 }
 
 func (suite *ConfigSuite) SetupTest() {
-	tempDB, err := os.CreateTemp("", "config-test.ini")
-	if err != nil {
-		suite.Fail(err.Error())
-	}
-	SetConfigAtPath(tempDB.Name())
+	SetConfigAtPath(filepath.Join(suite.T().TempDir(), "config-test.ini"))
 }
 
 func (suite *ConfigSuite) AfterTest(suiteName, testName string) {
@@ -52,6 +48,32 @@ func (suite *ConfigSuite) TestConfigWrite() {
 	}
 }
 
+func (suite *ConfigSuite) TestChannelPlan() {
+	SetChannelHopping(false)
+	if GetChannelPlan() != nil {
+		suite.Fail("hopping should be off by default")
+	}
+	SetChannelHopping(true)
+	SetChannelPlan("")
+	SetChannelBands("2.4")
+	suite.Equal([]int{1, 6, 11}, GetChannelPlan())
+	SetChannelPlan("1, 6, 36, bogus, 11")
+	SetChannelBands("2.4GHz,5GHz")
+	suite.Equal([]int{1, 6, 36, 11}, GetChannelPlan())
+	SetChannelBands("5")
+	suite.Equal([]int{36}, GetChannelPlan())
+	SetChannelHopping(false)
+}
+
+func (suite *ConfigSuite) TestFrameClasses() {
+	suite.Nil(GetFrameClasses())
+	SetFrameClasses("probe, Data, bogus")
+	suite.Equal([]string{"probe", "data"}, GetFrameClasses())
+	SetFrameClasses("probe,all")
+	suite.Nil(GetFrameClasses())
+	SetFrameClasses("all")
+}
+
 func TestConfigSuite(t *testing.T) {
 	suite.Run(t, new(ConfigSuite))
 }
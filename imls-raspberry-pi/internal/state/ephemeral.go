@@ -1,56 +1,229 @@
 package state
 
 import (
-	"crypto/sha1"
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
+
+	"gsa.gov/18f/internal/cryptopasta"
+	"gsa.gov/18f/internal/structs"
 )
 
 type StartEnd struct {
 	Start int64
 	End   int64
+	// The most telling frame class seen from the device.
+	Class      string
+	Randomized bool
+	// The oui category of the device's MAC.
+	Manufacturer int
+	// Library is set once the device is seen using the library's network.
+	Library bool
+	// Visit counts the earlier visits by the same address this session,
+	// so how much one device contributes to a count can be bounded.
+	Visit int
+	// Truncated is set when a reset cut the visit short, at either end.
+	Truncated bool
+	// Carried is set on a visit carried over the last reset, until the
+	// device is seen again.
+	Carried bool
+}
+
+// Extend merges a later sighting window of the same device into this one.
+func (se StartEnd) Extend(other StartEnd) StartEnd {
+	if other.Start < se.Start {
+		se.Start = other.Start
+	}
+	if other.End > se.End {
+		se.End = other.End
+	}
+	se.Class = moreTelling(se.Class, other.Class)
+	se.Randomized = se.Randomized && other.Randomized
+	if se.Manufacturer != other.Manufacturer {
+		se.Manufacturer = 0
+	}
+	se.Library = se.Library || other.Library
+	if other.Visit < se.Visit {
+		se.Visit = other.Visit
+	}
+	se.Truncated = se.Truncated || other.Truncated
+	se.Carried = se.Carried && other.Carried
+	return se
+}
+
+// Frame classes, from least to most telling about a device.
+var classRank = map[string]int{
+	"":                 0,
+	structs.FrameOther: 1,
+	structs.FrameProbe: 2,
+	structs.FrameAssoc: 3,
+	structs.FrameData:  4,
 }
 
+func moreTelling(a string, b string) string {
+	if classRank[b] > classRank[a] {
+		return b
+	}
+	return a
+}
+
+// EphemeralDB is keyed by HashMAC, never by a raw MAC address.
 type EphemeralDB map[string]StartEnd
 
-var ed EphemeralDB = make(EphemeralDB)
+// The session's devices; in memory only, unless OpenEphemeralStore says
+// otherwise.
+var store EphemeralStore = newMemoryStore()
 
+// ephemeralMu guards the store and the keys, which the captures and the
+// reset reach from their own goroutines.
+var ephemeralMu sync.Mutex
+
+// Visits carried over the reset are stored under the last session's key
+// until the device is seen again. That key is kept only until they can no
+// longer be extended, at `carriedUntil`; the sqlite store keeps it (sealed,
+// like the MAC key) so a restart in between can still extend them.
+var (
+	carry        EphemeralDB
+	carriedKey   *[32]byte
+	carriedUntil int64
+)
+
+// The key MACs are hashed with. It is replaced at every reset, so the
+// same device cannot be followed from one day to the next. With
+// `ephemeral.store` memory it only ever lives in memory, like the
+// fingerprint key; with sqlite it is also on disk, next to the hashes,
+// until the reset (sealed when `storage.encrypt` is on).
+var macKey = cryptopasta.NewEncryptionKey()
+
+// HashMAC is the keyed hash a MAC address is stored under.
+// NOTE: Do not log MAC addresses.
+func HashMAC(mac string) string {
+	ephemeralMu.Lock()
+	key := macKey
+	ephemeralMu.Unlock()
+	return hashMAC(key, mac)
+}
+
+func hashMAC(key *[32]byte, mac string) string {
+	h := hmac.New(sha256.New, key[:])
+	h.Write([]byte(strings.ToLower(mac)))
+	return fmt.Sprintf("mac:%x", h.Sum(nil))
+}
+
+// HashSighting replaces a sighting's MAC with its keyed hash. Anything
+// that needs the raw address (exclusions, the oui lookup) has to happen
+// before this; nothing after it sees the MAC.
+// NOTE: Do not log MAC addresses.
+func HashSighting(s structs.Sighting) structs.Sighting {
+	ephemeralMu.Lock()
+	defer ephemeralMu.Unlock()
+	hashed := hashMAC(macKey, s.MAC)
+	if carriedKey != nil && GetClock().Now().Unix() > carriedUntil {
+		carriedKey = nil
+	}
+	if carriedKey != nil {
+		// A device carried over the reset is back; move its visit over
+		// to the new key, joining anything already there.
+		old := hashMAC(carriedKey, s.MAC)
+		if se, ok := store.Get(old); ok {
+			store.Delete(old)
+			if existing, ok := store.Get(hashed); ok {
+				se = existing.Extend(se)
+			}
+			se.Carried = false
+			store.Put(hashed, se)
+		}
+	}
+	s.MAC = hashed
+	return s
+}
+
+// GetMACs is a copy of the session's devices, as they are now.
 func GetMACs() EphemeralDB {
-	return ed
+	ephemeralMu.Lock()
+	defer ephemeralMu.Unlock()
+	macs := make(EphemeralDB)
+	for k, se := range store.All() {
+		macs[k] = se
+	}
+	return macs
+}
+
+// CarryOver hands visits still going at the reset, keyed as they are
+// now, to the next session. They are stored there when ClearEphemeralDB
+// starts it.
+func CarryOver(visits EphemeralDB) {
+	ephemeralMu.Lock()
+	defer ephemeralMu.Unlock()
+	carry = visits
 }
 
 func ClearEphemeralDB() {
-	ed = make(EphemeralDB)
+	ephemeralMu.Lock()
+	carriedKey = nil
+	if len(carry) > 0 {
+		carriedKey = macKey
+		carriedUntil = GetClock().Now().Unix() + MAC_MEMORY_DURATION_SEC
+	}
+	macKey = cryptopasta.NewEncryptionKey()
+	store.Reset(GetCurrentSessionID(), macKey, carriedKey, carriedUntil)
+	for k, se := range carry {
+		se.Carried = true
+		store.Put(k, se)
+	}
+	carry = nil
+	ephemeralMu.Unlock()
+	clearChannelStats()
+	clearAdapterStats()
+	clearSignals()
+	clearFingerprints()
+	clearExcludedCache()
+	clearLearnedBSSIDs()
 }
 
 // NOTE: Do not log MAC addresses.
 func RecordMAC(mac string) {
+	RecordSighting(HashSighting(structs.Sighting{MAC: mac}))
+}
+
+// RecordSighting expects a sighting that has been through HashSighting.
+// NOTE: Do not log MAC addresses.
+func RecordSighting(s structs.Sighting) {
+	mac := s.MAC
+	library := onLibraryNetwork(s)
 	now := GetClock().Now().In(time.Local).Unix()
 	// cfg := GetConfig()
 	// cfg.Log().Debug("THE TIME IS NOW ", GetClock().Now().In(time.Local), " or ", now)
+	ephemeralMu.Lock()
+	defer ephemeralMu.Unlock()
 
 	// Check if we already have the MAC address in the ephemeral table.
-	if p, ok := ed[mac]; ok {
+	if p, ok := store.Get(mac); ok {
 		//cfg.Log().Debug(mac, " exists, updating")
 		// Has this device been away for more than 2 hours?
 		// Start by grabbing the start/end times.
-		se := ed[mac]
+		se := p
 		if (now > se.End) && ((now - se.End) > MAC_MEMORY_DURATION_SEC) {
 			// If it has been, we need to "forget" the old device.
 			// Do this by hashing the mac with the current time, store the original data
 			// unchanged, and create a new entry for the current mac address, in case we
 			// see it again (in less than 2h).
 			// cfg.Log().Debug(mac, " is an old mac, refreshing/changing")
-			sha1 := sha1.Sum([]byte(mac + fmt.Sprint(now)))
-			ed[fmt.Sprintf("%x", sha1)] = se
-			ed[mac] = StartEnd{Start: now, End: now}
+			store.Put(hashMAC(macKey, mac+fmt.Sprint(now)), se)
+			store.Put(mac, StartEnd{Start: now, End: now, Class: s.Class, Randomized: s.Randomized,
+				Manufacturer: s.Manufacturer, Library: library, Visit: se.Visit + 1})
 		} else {
 			// Just update the mac address. It has been less than 2h.
-			ed[mac] = StartEnd{Start: p.Start, End: now}
+			store.Put(mac, StartEnd{Start: p.Start, End: now, Class: moreTelling(p.Class, s.Class), Randomized: p.Randomized,
+				Manufacturer: p.Manufacturer, Library: p.Library || library, Visit: p.Visit, Truncated: p.Truncated})
 		}
 	} else {
 		// We have never seen the MAC address.
 		//cfg.Log().Debug(mac, " is new, inserting")
-		ed[mac] = StartEnd{Start: now, End: now}
+		store.Put(mac, StartEnd{Start: now, End: now, Class: s.Class, Randomized: s.Randomized,
+			Manufacturer: s.Manufacturer, Library: library})
 	}
 }
@@ -0,0 +1,374 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"gsa.gov/18f/internal/cryptopasta"
+)
+
+var ErrEphemeralInWWW = errors.New("the ephemeral store must be kept outside the web root")
+
+// An EphemeralStore holds the session's devices, keyed by HashMAC, until
+// the reset moves them to the durations table.
+type EphemeralStore interface {
+	Get(key string) (StartEnd, bool)
+	Put(key string, se StartEnd)
+	Delete(key string)
+	// All is everything in the store. It is not a copy.
+	All() EphemeralDB
+	// Reset empties the store for a new session, whose MACs are hashed
+	// with `key`. `carried`, if not nil, is the last session's key, which
+	// the visits carried over are stored under until `carriedUntil`.
+	Reset(session int64, key *[32]byte, carried *[32]byte, carriedUntil int64)
+}
+
+// GetEphemeralStore is "memory" to keep the session's devices only in
+// memory, as always, or "sqlite" to also keep them on disk at
+// `ephemeral.path`, so a crash or a power cut does not lose the day.
+// With sqlite, the promise that the day's MAC hashes and their key never
+// touch the disk no longer holds: both are written there until the
+// reset, and anyone who can read the file during the day can check it
+// for a MAC they know.
+func GetEphemeralStore() string {
+	return viper.GetString("ephemeral.store")
+}
+
+func SetEphemeralStore(kind string) {
+	viper.Set("ephemeral.store", kind)
+}
+
+func GetEphemeralPath() string {
+	return viper.GetString("ephemeral.path")
+}
+
+func SetEphemeralPath(path string) {
+	viper.Set("ephemeral.path", path)
+}
+
+// memoryStore is the session's devices in a map, and nothing else.
+type memoryStore struct {
+	ed EphemeralDB
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{ed: make(EphemeralDB)}
+}
+
+func (s *memoryStore) Get(key string) (StartEnd, bool) {
+	se, ok := s.ed[key]
+	return se, ok
+}
+
+func (s *memoryStore) Put(key string, se StartEnd) {
+	s.ed[key] = se
+}
+
+func (s *memoryStore) Delete(key string) {
+	delete(s.ed, key)
+}
+
+func (s *memoryStore) All() EphemeralDB {
+	return s.ed
+}
+
+func (s *memoryStore) Reset(session int64, key *[32]byte, carried *[32]byte, carriedUntil int64) {
+	s.ed = make(EphemeralDB)
+}
+
+// sqliteStore writes every change through to a SQLite database in WAL
+// mode, which survives the process dying at any point. Reads come from
+// memory. Only hashed MACs are written, but the key they are hashed with
+// has to be kept as well, or a restored store could not be added to, and
+// so does the last session's key while visits carried over are stored
+// under it; they are encrypted under the storage key.
+type sqliteStore struct {
+	memoryStore
+	db *sqlx.DB
+}
+
+type ephemeralRow struct {
+	MAC          string `db:"mac"`
+	Start        int64  `db:"start_time"`
+	End          int64  `db:"end_time"`
+	Class        string `db:"class"`
+	Randomized   bool   `db:"randomized"`
+	Manufacturer int    `db:"manufacturer"`
+	Library      bool   `db:"library"`
+	Visit        int    `db:"visit"`
+	Truncated    bool   `db:"truncated"`
+	Carried      bool   `db:"carried"`
+}
+
+type ephemeralSession struct {
+	Session      int64  `db:"session"`
+	Started      int64  `db:"started"`
+	Key          []byte `db:"mac_key"`
+	CarriedKey   []byte `db:"carried_key"`
+	CarriedUntil int64  `db:"carried_until"`
+}
+
+// restoredSession is what restore finds: the session, and the keys its
+// MACs were hashed with.
+type restoredSession struct {
+	session      int64
+	key          *[32]byte
+	carried      *[32]byte
+	carriedUntil int64
+}
+
+func NewSqliteEphemeralStore(path string) (*sqliteStore, error) {
+	if insideDir(path, GetWWWRoot()) {
+		return nil, ErrEphemeralInWWW
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sqlx.Open("sqlite3", path+"?mode=rwc&_journal_mode=WAL&_synchronous=NORMAL")
+	if err != nil {
+		return nil, err
+	}
+	// One writer; SQLite would only make the rest wait.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ephemeral (mac TEXT PRIMARY KEY, start_time INTEGER, end_time INTEGER,
+			class TEXT, randomized INTEGER, manufacturer INTEGER, library INTEGER, visit INTEGER,
+			truncated INTEGER, carried INTEGER)`,
+		`CREATE TABLE IF NOT EXISTS ephemeral_session (session INTEGER, started INTEGER, mac_key BLOB,
+			carried_key BLOB, carried_until INTEGER)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// Columns added since a store could first have been written.
+	for _, added := range []struct {
+		table   string
+		columns []string
+	}{
+		{"ephemeral", []string{"truncated INTEGER DEFAULT 0", "carried INTEGER DEFAULT 0"}},
+		{"ephemeral_session", []string{"carried_key BLOB", "carried_until INTEGER DEFAULT 0"}},
+	} {
+		if err := addEphemeralColumns(db, added.table, added.columns); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	os.Chmod(path, 0600)
+	return &sqliteStore{memoryStore: *newMemoryStore(), db: db}, nil
+}
+
+// addEphemeralColumns adds those of `columns` (each a name and its type)
+// that `table` does not have yet.
+func addEphemeralColumns(db *sqlx.DB, table string, columns []string) error {
+	existing := []struct {
+		Name string `db:"name"`
+	}{}
+	if err := db.Select(&existing, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table)); err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, c := range existing {
+		have[c.Name] = true
+	}
+	for _, column := range columns {
+		if have[strings.Fields(column)[0]] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Put(key string, se StartEnd) {
+	s.memoryStore.Put(key, se)
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO ephemeral
+		(mac, start_time, end_time, class, randomized, manufacturer, library, visit, truncated, carried)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, se.Start, se.End, se.Class, se.Randomized, se.Manufacturer, se.Library, se.Visit, se.Truncated, se.Carried)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not write to the ephemeral store")
+	}
+}
+
+func (s *sqliteStore) Delete(key string) {
+	s.memoryStore.Delete(key)
+	if _, err := s.db.Exec("DELETE FROM ephemeral WHERE mac=?", key); err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not write to the ephemeral store")
+	}
+}
+
+func (s *sqliteStore) Reset(session int64, key *[32]byte, carried *[32]byte, carriedUntil int64) {
+	s.memoryStore.Reset(session, key, carried, carriedUntil)
+	if err := s.reset(session, key, carried, carriedUntil); err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not reset the ephemeral store")
+	}
+}
+
+func (s *sqliteStore) reset(session int64, key *[32]byte, carried *[32]byte, carriedUntil int64) error {
+	sealed, err := sealStoreKey(key)
+	if err != nil {
+		return err
+	}
+	var sealedCarried []byte
+	if carried != nil {
+		if sealedCarried, err = sealStoreKey(carried); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{"DELETE FROM ephemeral", "DELETE FROM ephemeral_session"} {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO ephemeral_session (session, started, mac_key, carried_key, carried_until)
+		VALUES (?, ?, ?, ?, ?)`,
+		session, GetClock().Now().Unix(), sealed, sealedCarried, carriedUntil)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// restore loads what was stored, if it is still the same session: that
+// is, if no reset has come due since it was last written to.
+func (s *sqliteStore) restore(nextReset func(time.Time) time.Time) (restoredSession, error) {
+	restored := restoredSession{}
+	saved := ephemeralSession{}
+	if err := s.db.Get(&saved, "SELECT * FROM ephemeral_session"); err != nil {
+		return restored, err
+	}
+	rows := []ephemeralRow{}
+	if err := s.db.Select(&rows, "SELECT * FROM ephemeral"); err != nil {
+		return restored, err
+	}
+	last := saved.Started
+	for _, r := range rows {
+		if r.End > last {
+			last = r.End
+		}
+	}
+	if due := nextReset(time.Unix(last, 0)); !GetClock().Now().Before(due) {
+		return restored, fmt.Errorf("the stored session missed its reset at %v", due)
+	}
+	key, err := unsealStoreKey(saved.Key)
+	if err != nil {
+		return restored, err
+	}
+	// The carried key is no use once it has run out.
+	var carried *[32]byte
+	if saved.CarriedKey != nil && GetClock().Now().Unix() <= saved.CarriedUntil {
+		if carried, err = unsealStoreKey(saved.CarriedKey); err != nil {
+			return restored, err
+		}
+	}
+	for _, r := range rows {
+		s.memoryStore.Put(r.MAC, StartEnd{Start: r.Start, End: r.End, Class: r.Class, Randomized: r.Randomized,
+			Manufacturer: r.Manufacturer, Library: r.Library, Visit: r.Visit, Truncated: r.Truncated, Carried: r.Carried})
+	}
+	restored.session = saved.Session
+	restored.key = key
+	if carried != nil {
+		restored.carried = carried
+		restored.carriedUntil = saved.CarriedUntil
+	}
+	return restored, nil
+}
+
+// sealStoreKey encrypts the session's MAC key under the storage key,
+// whether or not the databases are encrypted at rest: with the MAC key,
+// anyone could hash a MAC address and look for it in the store.
+func sealStoreKey(key *[32]byte) ([]byte, error) {
+	storageKey, err := StorageKey()
+	if err != nil {
+		return nil, err
+	}
+	return cryptopasta.Encrypt(key[:], storageKey)
+}
+
+func unsealStoreKey(sealed []byte) (*[32]byte, error) {
+	storageKey, err := StorageKey()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := cryptopasta.Decrypt(sealed, storageKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) != 32 {
+		return nil, errors.New("the stored key is the wrong length")
+	}
+	key := [32]byte{}
+	copy(key[:], plain)
+	return &key, nil
+}
+
+// OpenEphemeralStore sets up the store `ephemeral.store` asks for. An
+// on-disk store picks up where the last run left off (the devices, the
+// keys they were hashed with, and the session ID) unless a reset has come
+// due since; then it starts the current session afresh.
+func OpenEphemeralStore() {
+	if GetEphemeralStore() != "sqlite" {
+		return
+	}
+	s, err := NewSqliteEphemeralStore(GetEphemeralPath())
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("path", GetEphemeralPath()).
+			Msg("could not open the ephemeral store; keeping devices in memory")
+		return
+	}
+	schedule, err := cron.ParseStandard(GetResetCron())
+	if err == nil {
+		var restored restoredSession
+		restored, err = s.restore(schedule.Next)
+		if err == nil {
+			ephemeralMu.Lock()
+			setCurrentSessionID(restored.session)
+			macKey = restored.key
+			carriedKey = restored.carried
+			carriedUntil = restored.carriedUntil
+			store = s
+			ephemeralMu.Unlock()
+			log.Info().
+				Int64("session", restored.session).
+				Int("devices", len(s.All())).
+				Msg("restored the ephemeral store")
+			return
+		}
+	}
+	if err != sql.ErrNoRows {
+		log.Info().
+			Err(err).
+			Msg("not restoring the ephemeral store")
+	}
+	ephemeralMu.Lock()
+	s.Reset(GetCurrentSessionID(), macKey, carriedKey, carriedUntil)
+	store = s
+	ephemeralMu.Unlock()
+}
@@ -0,0 +1,183 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/jmoiron/sqlx"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestEphemeralStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigAtPath(filepath.Join(dir, "test.ini"))
+	SetEphemeralStore("sqlite")
+	SetEphemeralPath(filepath.Join(dir, "state", "ephemeral.sqlite"))
+	SetStorageSecretPath(filepath.Join(dir, "etc", "storage.secret"))
+	SetResetCron("0 0 * * *")
+	defer func() {
+		SetEphemeralStore("memory")
+		store = newMemoryStore()
+	}()
+
+	mock := clock.NewMock()
+	mock.Set(time.Date(1975, 10, 11, 9, 0, 0, 0, time.Local))
+	SetClock(mock)
+	IncrementSessionID()
+	session := GetCurrentSessionID()
+	OpenEphemeralStore()
+	ClearEphemeralDB()
+	RecordSighting(HashSighting(structs.Sighting{MAC: "DE:AD:BE:EF:00:00", Class: structs.FrameData}))
+	mock.Add(time.Hour)
+	RecordSighting(HashSighting(structs.Sighting{MAC: "DE:AD:BE:EF:00:00"}))
+	RecordSighting(HashSighting(structs.Sighting{MAC: "BE:EF:00:00:00:00"}))
+	before := GetMACs()
+
+	// Power cut. Everything in memory is gone.
+	store = newMemoryStore()
+	macKey = nil
+	setCurrentSessionID(0)
+	mock.Add(time.Hour)
+	OpenEphemeralStore()
+
+	if GetCurrentSessionID() != session || len(GetMACs()) != 2 {
+		t.Fatal("expected the session to be restored, got ", GetCurrentSessionID(), GetMACs())
+	}
+	for k, se := range before {
+		if GetMACs()[k] != se {
+			t.Error("expected ", se, ", restored ", GetMACs()[k])
+		}
+	}
+	// The same device is still the same device.
+	RecordSighting(HashSighting(structs.Sighting{MAC: "DE:AD:BE:EF:00:00"}))
+	se := GetMACs()[HashMAC("DE:AD:BE:EF:00:00")]
+	if len(GetMACs()) != 2 || se.End != mock.Now().Unix() || se.Class != structs.FrameData {
+		t.Error("expected the restored device to be extended ", se)
+	}
+
+	// Down past midnight: that was yesterday's session, and it is
+	// not today's.
+	store = newMemoryStore()
+	mock.Add(16 * time.Hour)
+	OpenEphemeralStore()
+	if len(GetMACs()) != 0 || GetCurrentSessionID() != session {
+		t.Error("a session that missed its reset should not be restored ", GetMACs())
+	}
+
+	// Nothing is stored in the clear, not even the key the MACs are
+	// hashed with.
+	for _, f := range []string{GetEphemeralPath(), GetEphemeralPath() + "-wal"} {
+		data, _ := ioutil.ReadFile(f)
+		if strings.Contains(strings.ToLower(string(data)), "de:ad:be:ef") {
+			t.Error("found a MAC address in ", f)
+		}
+		if bytes.Contains(data, macKey[:]) {
+			t.Error("found the MAC key in ", f)
+		}
+	}
+}
+
+func TestEphemeralStoreRestoresCarriedKey(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigAtPath(filepath.Join(dir, "test.ini"))
+	SetEphemeralStore("sqlite")
+	SetEphemeralPath(filepath.Join(dir, "state", "ephemeral.sqlite"))
+	SetStorageSecretPath(filepath.Join(dir, "etc", "storage.secret"))
+	SetResetCron("0 0 * * *")
+	defer func() {
+		SetEphemeralStore("memory")
+		store = newMemoryStore()
+	}()
+
+	mock := clock.NewMock()
+	mock.Set(time.Date(1975, 10, 11, 23, 30, 0, 0, time.Local))
+	SetClock(mock)
+	OpenEphemeralStore()
+	ClearEphemeralDB()
+	mac := "DE:AD:BE:EF:00:00"
+	RecordSighting(HashSighting(structs.Sighting{MAC: mac}))
+	// Still there at midnight.
+	mock.Add(30 * time.Minute)
+	CarryOver(GetMACs())
+	IncrementSessionID()
+	ClearEphemeralDB()
+	carried := carriedKey
+
+	// Power cut, and back up within the hour.
+	store = newMemoryStore()
+	macKey = nil
+	carriedKey = nil
+	carriedUntil = 0
+	mock.Add(30 * time.Minute)
+	OpenEphemeralStore()
+
+	if carriedKey == nil || *carriedKey != *carried {
+		t.Fatal("expected the carried key to be restored")
+	}
+	// The device is back, and its visit goes on under the new key.
+	HashSighting(structs.Sighting{MAC: mac})
+	se, ok := GetMACs()[HashMAC(mac)]
+	if len(GetMACs()) != 1 || !ok || se.Carried {
+		t.Error("expected the carried visit to move to the new key ", GetMACs())
+	}
+
+	data, _ := ioutil.ReadFile(GetEphemeralPath())
+	if bytes.Contains(data, carried[:]) {
+		t.Error("found the carried key in the clear")
+	}
+
+	// Past when the carried key runs out, it is not restored.
+	store = newMemoryStore()
+	carriedKey = nil
+	mock.Add(MAC_MEMORY_DURATION_SEC * time.Second)
+	OpenEphemeralStore()
+	if carriedKey != nil {
+		t.Error("a carried key that has run out should not be restored")
+	}
+}
+
+func TestEphemeralStoreAddsColumns(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigAtPath(filepath.Join(dir, "test.ini"))
+	SetStorageSecretPath(filepath.Join(dir, "etc", "storage.secret"))
+	path := filepath.Join(dir, "ephemeral.sqlite")
+	// A store from before visits could be truncated or carried.
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`CREATE TABLE ephemeral (mac TEXT PRIMARY KEY, start_time INTEGER, end_time INTEGER,
+		class TEXT, randomized INTEGER, manufacturer INTEGER, library INTEGER, visit INTEGER)`)
+	db.MustExec(`CREATE TABLE ephemeral_session (session INTEGER, started INTEGER, mac_key BLOB)`)
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		s, err := NewSqliteEphemeralStore(path)
+		if err != nil {
+			t.Fatal("could not open the store ", err)
+		}
+		s.Put("key", StartEnd{Start: 1, End: 2, Truncated: true, Carried: true})
+		var carried bool
+		if err := s.db.Get(&carried, "SELECT carried FROM ephemeral WHERE mac='key'"); err != nil || !carried {
+			t.Error("expected the new columns to be written ", err)
+		}
+		if err := s.reset(1, macKey, macKey, 2); err != nil {
+			t.Error("expected the carried key to be written ", err)
+		}
+		s.db.Close()
+	}
+}
+
+func TestEphemeralStoreNotInWWW(t *testing.T) {
+	dir := t.TempDir()
+	defer SetRootPath(GetWWWRoot())
+	SetRootPath(dir)
+	if _, err := NewSqliteEphemeralStore(filepath.Join(dir, "ephemeral.sqlite")); err != ErrEphemeralInWWW {
+		t.Error("expected the store to be refused under the web root, got ", err)
+	}
+}
@@ -0,0 +1,80 @@
+package state
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestHashMAC(t *testing.T) {
+	ClearEphemeralDB()
+	SetClock(clock.NewMock())
+	mac := "DE:AD:BE:EF:00:00"
+	hashed := HashMAC(mac)
+	if strings.Contains(hashed, "de:ad:be:ef") || hashed != HashMAC(strings.ToLower(mac)) {
+		t.Error("expected the same opaque hash for the same MAC, got ", hashed)
+	}
+
+	RecordSighting(HashSighting(structs.Sighting{MAC: mac, Manufacturer: 3}))
+	se, ok := GetMACs()[hashed]
+	if !ok || se.Manufacturer != 3 || len(GetMACs()) != 1 {
+		t.Error("expected the sighting to be stored under its hash ", GetMACs())
+	}
+	if _, ok := GetMACs()[mac]; ok {
+		t.Error("the raw MAC should never be a key")
+	}
+
+	// A new session gets a new key.
+	ClearEphemeralDB()
+	if HashMAC(mac) == hashed {
+		t.Error("expected the key to change at reset")
+	}
+}
+
+func TestCarriedVisitJoinsNewKey(t *testing.T) {
+	ClearEphemeralDB()
+	mock := clock.NewMock()
+	SetClock(mock)
+	mac := "DE:AD:BE:EF:00:00"
+	mock.Add(time.Hour)
+	CarryOver(EphemeralDB{HashMAC(mac): StartEnd{Start: 0, End: 3600, Class: structs.FrameData}})
+	ClearEphemeralDB()
+
+	// Somehow already seen under the new key.
+	hashed := HashMAC(mac)
+	store.Put(hashed, StartEnd{Start: 3660, End: 3700, Class: structs.FrameProbe})
+	mock.Add(2 * time.Minute)
+	HashSighting(structs.Sighting{MAC: mac})
+
+	se, ok := GetMACs()[hashed]
+	if len(GetMACs()) != 1 || !ok {
+		t.Fatal("expected one visit under the new key ", GetMACs())
+	}
+	if se.Start != 0 || se.End != 3700 || se.Class != structs.FrameData || se.Carried {
+		t.Error("expected the carried visit to be joined with the new one ", se)
+	}
+}
+
+func TestVisits(t *testing.T) {
+	ClearEphemeralDB()
+	mock := clock.NewMock()
+	SetClock(mock)
+	s := HashSighting(structs.Sighting{MAC: "DE:AD:BE:EF:00:00"})
+	RecordSighting(s)
+	mock.Add(time.Hour)
+	RecordSighting(s)
+	// Gone long enough to be forgotten, then back.
+	mock.Add(3 * time.Hour)
+	RecordSighting(s)
+
+	visits := make(map[int]int)
+	for _, se := range GetMACs() {
+		visits[se.Visit] += 1
+	}
+	if len(GetMACs()) != 2 || visits[0] != 1 || visits[1] != 1 {
+		t.Error("expected a first and a second visit ", GetMACs())
+	}
+}
@@ -0,0 +1,200 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"gsa.gov/18f/internal/cryptopasta"
+)
+
+// Devices we never count: access points, printers, staff laptops, and
+// so on. The list in `exclude.list` holds only keyed hashes of the
+// addresses, as "mac:<hash>" or "oui:<hash>", so the ini file does not
+// give away the devices in the building. The key is kept apart from the
+// list, in the file at `exclude.keyfile`, readable only by us; with both,
+// the hashes could be reversed by trying every address.
+
+var ErrBadExclusion = errors.New("exclusions must be a MAC address, BSSID, or OUI prefix")
+
+var ErrExclusionKeyInWWW = errors.New("the exclusion key must be kept outside the web root")
+
+// The key, and the file it was read from.
+var (
+	exclusionKeyMu   sync.Mutex
+	exclusionKeyPath string
+	exclusionKeyRead []byte
+)
+
+// The parsed `exclude.list`, and the value it was parsed from.
+var (
+	exclusionSource string
+	exclusions      map[string]bool
+)
+
+// Whether each MAC seen this session is excluded, so we hash each one
+// only once. Cleared alongside the ephemeral DB.
+var excludedCache = make(map[string]bool)
+
+// excludeMu guards the parsed list and excludedCache; each capture
+// checks its sightings from its own goroutine.
+var excludeMu sync.Mutex
+
+func GetExclusionKeyPath() string {
+	return viper.GetString("exclude.keyfile")
+}
+
+func SetExclusionKeyPath(path string) {
+	viper.Set("exclude.keyfile", path)
+}
+
+// exclusionKey reads the key from `exclude.keyfile`. Lists made before the
+// key had a file of its own were hashed with `exclude.key`, from the ini;
+// that is used until AddExclusions moves it.
+func exclusionKey() []byte {
+	exclusionKeyMu.Lock()
+	defer exclusionKeyMu.Unlock()
+	path := GetExclusionKeyPath()
+	if path == exclusionKeyPath && exclusionKeyRead != nil {
+		return exclusionKeyRead
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		key, _ := hex.DecodeString(viper.GetString("exclude.key"))
+		return key
+	}
+	key, _ := hex.DecodeString(strings.TrimSpace(string(b)))
+	exclusionKeyPath, exclusionKeyRead = path, key
+	return key
+}
+
+// makeExclusionKey writes the key file the first time it is needed, with
+// the key from the ini if there was one, and takes the key out of the ini.
+func makeExclusionKey() error {
+	path := GetExclusionKeyPath()
+	if insideDir(path, GetWWWRoot()) {
+		return ErrExclusionKeyInWWW
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		key, _ := hex.DecodeString(viper.GetString("exclude.key"))
+		if len(key) == 0 {
+			newKey := cryptopasta.NewEncryptionKey()
+			key = newKey[:]
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = ioutil.WriteFile(path, []byte(hex.EncodeToString(key)), 0600)
+		}
+		if err != nil {
+			return fmt.Errorf("exclusion key %s: %w", path, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("exclusion key %s: %w", path, err)
+	}
+	viper.Set("exclude.key", "")
+	return nil
+}
+
+func exclusionHash(kind string, value string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("%s:%x", kind, mac.Sum(nil))
+}
+
+// ExclusionHash normalizes a MAC address, BSSID, or three-octet OUI
+// prefix, and returns the entry `exclude.list` should hold for it.
+func ExclusionHash(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if hw, err := net.ParseMAC(entry); err == nil && len(hw) == 6 {
+		return exclusionHash("mac", hw.String(), exclusionKey()), nil
+	}
+	// An OUI is three octets, in any of the separators net.ParseMAC allows.
+	digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(entry)
+	if oui, err := hex.DecodeString(digits); err == nil && len(oui) == 3 {
+		hw := net.HardwareAddr(append(oui, 0, 0, 0))
+		return exclusionHash("oui", hw.String()[:8], exclusionKey()), nil
+	}
+	return "", ErrBadExclusion
+}
+
+// AddExclusions hashes the entries into `exclude.list` and writes the
+// config back out. The key is made the first time it is needed.
+func AddExclusions(entries []string) error {
+	if err := makeExclusionKey(); err != nil {
+		return err
+	}
+	list := GetExclusions()
+	have := make(map[string]bool)
+	for _, h := range list {
+		have[h] = true
+	}
+	for _, e := range entries {
+		h, err := ExclusionHash(e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e, err)
+		}
+		if !have[h] {
+			list = append(list, h)
+			have[h] = true
+		}
+	}
+	viper.Set("exclude.list", strings.Join(list, ","))
+	return viper.WriteConfig()
+}
+
+// GetExclusions returns the hashed entries in `exclude.list`.
+func GetExclusions() []string {
+	list := make([]string, 0)
+	for _, h := range strings.Split(viper.GetString("exclude.list"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			list = append(list, h)
+		}
+	}
+	return list
+}
+
+// IsExcluded says whether a MAC, or its OUI, is on the exclusion list.
+// NOTE: Do not log MAC addresses.
+func IsExcluded(mac string) bool {
+	excludeMu.Lock()
+	defer excludeMu.Unlock()
+	if viper.GetString("exclude.list") != exclusionSource {
+		exclusionSource = viper.GetString("exclude.list")
+		exclusions = make(map[string]bool)
+		for _, h := range GetExclusions() {
+			exclusions[h] = true
+		}
+		excludedCache = make(map[string]bool)
+	}
+	if len(exclusions) == 0 {
+		return false
+	}
+	// The cache lives all day, so it is keyed by hash too.
+	hashed := HashMAC(mac)
+	if excluded, ok := excludedCache[hashed]; ok {
+		return excluded
+	}
+
+	excluded := false
+	if hw, err := net.ParseMAC(mac); err == nil && len(hw) == 6 {
+		key := exclusionKey()
+		excluded = exclusions[exclusionHash("mac", hw.String(), key)] ||
+			exclusions[exclusionHash("oui", hw.String()[:8], key)]
+	}
+	excludedCache[hashed] = excluded
+	return excluded
+}
+
+func clearExcludedCache() {
+	excludeMu.Lock()
+	defer excludeMu.Unlock()
+	excludedCache = make(map[string]bool)
+}
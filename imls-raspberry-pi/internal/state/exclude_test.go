@@ -0,0 +1,81 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestExclusions(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigAtPath(filepath.Join(dir, "exclude.ini"))
+	SetExclusionKeyPath(filepath.Join(dir, "etc", "exclude.key"))
+	defer viper.Set("exclude.list", "")
+
+	err := AddExclusions([]string{"00:11:22:33:44:55", "B8-27-EB", "00:11:22:33:44:55"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(GetExclusions()) != 2 {
+		t.Error("duplicate entries should only be stored once, found ", GetExclusions())
+	}
+	if AddExclusions([]string{"printer"}) == nil {
+		t.Error("expected an error for something that is not an address")
+	}
+
+	for mac, want := range map[string]bool{
+		"00:11:22:33:44:55": true,
+		"00:11:22:33:44:56": false,
+		"b8:27:eb:00:00:04": true,
+		"B8:27:EB:12:34:56": true,
+		"f0:18:98:00:00:01": false,
+	} {
+		if IsExcluded(mac) != want {
+			t.Error("expected IsExcluded to be ", want, " for ", mac)
+		}
+	}
+
+	// Only hashes make it to the config, and the key is kept apart.
+	b, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.ToLower(string(b)), "b8:27:eb") ||
+		!strings.Contains(string(b), "oui:") {
+		t.Error("the config should hold hashed exclusions only")
+	}
+	key, err := os.ReadFile(GetExclusionKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), strings.TrimSpace(string(key))) {
+		t.Error("the exclusion key should not be in the config")
+	}
+	if fi, err := os.Stat(GetExclusionKeyPath()); err != nil || fi.Mode().Perm() != 0600 {
+		t.Error("the exclusion key should only be readable by us")
+	}
+}
+
+func TestExclusionKeyMovedOutOfConfig(t *testing.T) {
+	dir := t.TempDir()
+	SetConfigAtPath(filepath.Join(dir, "exclude.ini"))
+	SetExclusionKeyPath(filepath.Join(dir, "etc", "exclude.key"))
+	defer viper.Set("exclude.list", "")
+
+	// A list made when the key was kept in the ini.
+	viper.Set("exclude.key", "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	h, _ := ExclusionHash("00:11:22:33:44:55")
+	viper.Set("exclude.list", h)
+	if err := AddExclusions([]string{"b8:27:eb"}); err != nil {
+		t.Fatal(err)
+	}
+	if viper.GetString("exclude.key") != "" {
+		t.Error("the key should be taken out of the config")
+	}
+	if !IsExcluded("00:11:22:33:44:55") || !IsExcluded("b8:27:eb:00:00:01") {
+		t.Error("the old entries should still match under the moved key")
+	}
+}
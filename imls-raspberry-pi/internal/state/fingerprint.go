@@ -0,0 +1,109 @@
+package state
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"gsa.gov/18f/internal/cryptopasta"
+	"gsa.gov/18f/internal/structs"
+)
+
+// The key fingerprints are hashed with. It only ever lives in memory, and
+// is replaced alongside the ephemeral DB, so a fingerprint from one day
+// cannot be matched against another's.
+var fingerprintKey = cryptopasta.NewEncryptionKey()
+
+// A fingerprintGroup is one device rotating its MAC: the MAC it is using
+// now, and when that was last seen.
+type fingerprintGroup struct {
+	name     string
+	member   string
+	lastSeen int64
+}
+
+// Which group each randomized MAC has joined, and the groups for each
+// fingerprint. Phones of the same model send the same fingerprint, so
+// there can be several groups at once.
+var (
+	fingerprintGroups = make(map[string]*fingerprintGroup)
+	fingerprintChains = make(map[string][]*fingerprintGroup)
+)
+
+// fingerprintMu guards fingerprintKey and the groups.
+var fingerprintMu sync.Mutex
+
+// Fingerprint hashes the material from a probe request's information
+// elements into a group name that stands in for a MAC address.
+func Fingerprint(material string) string {
+	fingerprintMu.Lock()
+	key := fingerprintKey
+	fingerprintMu.Unlock()
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(material))
+	return fmt.Sprintf("fp:%x", mac.Sum(nil)[:8])
+}
+
+// GroupSighting replaces the MAC of a randomized sighting with its
+// fingerprint group, if fingerprinting is on and the MAC has been seen
+// probing. A new MAC only joins a group whose MAC went quiet before it
+// appeared, within `randomized.collapse_gap`; one that is still around
+// is another device, and gets a group of its own ("fp:<hash>#1", and so
+// on). Frames without a fingerprint of their own (data frames, say) are
+// grouped by the MAC's group.
+// NOTE: Do not log MAC addresses.
+func GroupSighting(s structs.Sighting) structs.Sighting {
+	if !GetFingerprinting() || !s.Randomized {
+		return s
+	}
+	now := GetClock().Now().Unix()
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	g, ok := fingerprintGroups[s.MAC]
+	if !ok && s.Fingerprint != "" {
+		g = joinGroup(s.Fingerprint, s.MAC, now)
+		fingerprintGroups[s.MAC] = g
+	}
+	if g == nil {
+		return s
+	}
+	if g.member == s.MAC {
+		g.lastSeen = now
+	}
+	s.MAC = g.name
+	return s
+}
+
+// joinGroup finds the group a new MAC probing with `fingerprint` carries
+// on from: the one that went quiet most recently before `now`, within the
+// collapse gap. Failing that, it starts a new one.
+func joinGroup(fingerprint string, mac string, now int64) *fingerprintGroup {
+	gap := int64(GetRandomizedCollapseGap() * 60)
+	var best *fingerprintGroup
+	for _, g := range fingerprintChains[fingerprint] {
+		if g.lastSeen < now && now-g.lastSeen <= gap &&
+			(best == nil || g.lastSeen > best.lastSeen) {
+			best = g
+		}
+	}
+	if best == nil {
+		name := fingerprint
+		if n := len(fingerprintChains[fingerprint]); n > 0 {
+			name = fmt.Sprintf("%s#%d", fingerprint, n)
+		}
+		best = &fingerprintGroup{name: name}
+		fingerprintChains[fingerprint] = append(fingerprintChains[fingerprint], best)
+	}
+	best.member = mac
+	best.lastSeen = now
+	return best
+}
+
+func clearFingerprints() {
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	fingerprintKey = cryptopasta.NewEncryptionKey()
+	fingerprintGroups = make(map[string]*fingerprintGroup)
+	fingerprintChains = make(map[string][]*fingerprintGroup)
+}
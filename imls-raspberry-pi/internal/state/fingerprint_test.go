@@ -0,0 +1,85 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestFingerprintRotates(t *testing.T) {
+	ClearEphemeralDB()
+	before := Fingerprint("0,1,50\t2,4,11,22")
+	if before != Fingerprint("0,1,50\t2,4,11,22") {
+		t.Error("the same material should give the same fingerprint within a day")
+	}
+	ClearEphemeralDB()
+	if before == Fingerprint("0,1,50\t2,4,11,22") {
+		t.Error("fingerprints should not match across a reset")
+	}
+}
+
+func TestGroupSighting(t *testing.T) {
+	SetClock(clock.NewMock())
+	ClearEphemeralDB()
+	SetFingerprinting(true)
+	defer SetFingerprinting(false)
+
+	probe := structs.Sighting{MAC: "da:a1:19:00:00:01", Randomized: true, Fingerprint: "fp:1234"}
+	if GroupSighting(probe).MAC != "fp:1234" {
+		t.Error("a probe request should be grouped by its fingerprint")
+	}
+	data := structs.Sighting{MAC: "da:a1:19:00:00:01", Randomized: true}
+	if GroupSighting(data).MAC != "fp:1234" {
+		t.Error("later frames from the same MAC should join its group")
+	}
+	global := structs.Sighting{MAC: "f0:18:98:00:00:01", Fingerprint: "fp:1234"}
+	if GroupSighting(global).MAC != global.MAC {
+		t.Error("global MACs should not be grouped")
+	}
+	ClearEphemeralDB()
+	if GroupSighting(data).MAC != data.MAC {
+		t.Error("groups should be forgotten at reset")
+	}
+}
+
+func TestGroupSightingSameModel(t *testing.T) {
+	mock := clock.NewMock()
+	SetClock(mock)
+	ClearEphemeralDB()
+	SetFingerprinting(true)
+	defer SetFingerprinting(false)
+	SetRandomizedCollapseGap(2)
+
+	// Two phones of the same model, in the building at once.
+	for _, mac := range []string{"da:a1:19:00:00:01", "da:a1:19:00:00:02"} {
+		RecordSighting(GroupSighting(structs.Sighting{MAC: mac, Randomized: true, Fingerprint: "fp:1234"}))
+	}
+	mock.Add(time.Minute)
+	for _, mac := range []string{"da:a1:19:00:00:01", "da:a1:19:00:00:02"} {
+		RecordSighting(GroupSighting(structs.Sighting{MAC: mac, Randomized: true}))
+	}
+	if len(GetMACs()) != 2 {
+		t.Error("expected two devices, found ", len(GetMACs()))
+	}
+
+	// One of them rotates its MAC: the new one carries on its group.
+	mock.Add(time.Minute)
+	RecordSighting(GroupSighting(structs.Sighting{MAC: "da:a1:19:00:00:02", Randomized: true}))
+	s := GroupSighting(structs.Sighting{MAC: "da:a1:19:00:00:03", Randomized: true, Fingerprint: "fp:1234"})
+	if s.MAC != "fp:1234" {
+		t.Error("expected the rotated MAC to join the quiet group, got ", s.MAC)
+	}
+	RecordSighting(s)
+	if len(GetMACs()) != 2 {
+		t.Error("expected still two devices, found ", len(GetMACs()))
+	}
+
+	// Too long after the last went quiet, it is a new device.
+	mock.Add(3 * time.Minute)
+	s = GroupSighting(structs.Sighting{MAC: "da:a1:19:00:00:04", Randomized: true, Fingerprint: "fp:1234"})
+	if s.MAC == "fp:1234" || s.MAC == "fp:1234#1" {
+		t.Error("expected a new group after the collapse gap, got ", s.MAC)
+	}
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// CaptureHealth is how capture is going on one adapter, as the capture
+// supervisor sees it. The empty adapter name stands for device discovery.
+type CaptureHealth struct {
+	Healthy bool
+	// One of the tlp.Failure* kinds, when unhealthy.
+	Failure string
+	Error   string
+	// Failures in a row; reset by a good capture.
+	Failures int
+	// When the adapter last went healthy or unhealthy.
+	Since time.Time
+	// When the supervisor will next try, when unhealthy.
+	NextAttempt time.Time
+}
+
+// The capture goroutines write this, and anyone may read it.
+var (
+	healthMu sync.Mutex
+	health   = make(map[string]CaptureHealth)
+)
+
+func SetCaptureHealth(adapter string, h CaptureHealth) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if old, ok := health[adapter]; ok && old.Healthy == h.Healthy {
+		h.Since = old.Since
+	}
+	health[adapter] = h
+}
+
+// GetCaptureHealth returns the health of every adapter we have tried to
+// capture on.
+func GetCaptureHealth() map[string]CaptureHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	copied := make(map[string]CaptureHealth)
+	for adapter, h := range health {
+		copied[adapter] = h
+	}
+	return copied
+}
+
+// CaptureHealthy says whether every adapter we know of is capturing.
+func CaptureHealthy() bool {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	for _, h := range health {
+		if !h.Healthy {
+			return false
+		}
+	}
+	return true
+}
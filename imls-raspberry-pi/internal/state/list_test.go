@@ -2,6 +2,7 @@ package state
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -11,11 +12,7 @@ type ListSuite struct {
 }
 
 func (suite *ListSuite) SetupTest() {
-	temp, err := os.CreateTemp("", "list-test.ini")
-	if err != nil {
-		suite.Fail(err.Error())
-	}
-	SetConfigAtPath(temp.Name())
+	SetConfigAtPath(filepath.Join(suite.T().TempDir(), "list-test.ini"))
 }
 
 func (suite *ListSuite) AfterTest(suiteName, testName string) {
@@ -0,0 +1,84 @@
+package state
+
+import (
+	"strings"
+	"sync"
+
+	"gsa.gov/18f/internal/structs"
+)
+
+// BSSIDs learned from beacons for the SSIDs in `network.ssids`. They are
+// forgotten at the reset, so a hotspot named for the library does not
+// count for long.
+var learnedBSSIDs = make(map[string]bool)
+
+// learnedMu guards learnedBSSIDs.
+var learnedMu sync.Mutex
+
+// RecordBeacon notes the BSSID of a beacon or probe response if it
+// names one of the library's networks. If `network.bssids` is set, the
+// BSSID also has to share an OUI with one of those.
+func RecordBeacon(ssid string, bssid string) {
+	bssid = strings.ToLower(bssid)
+	if len(bssid) < 8 {
+		return
+	}
+	named := false
+	for _, s := range GetLibrarySSIDs() {
+		named = named || s == ssid
+	}
+	if !named {
+		return
+	}
+	if configured := GetLibraryBSSIDs(); len(configured) > 0 {
+		sameOUI := false
+		for _, b := range configured {
+			sameOUI = sameOUI || strings.HasPrefix(b, bssid[:8])
+		}
+		if !sameOUI {
+			return
+		}
+	}
+	learnedMu.Lock()
+	learnedBSSIDs[bssid] = true
+	learnedMu.Unlock()
+}
+
+// IsLibraryBSSID says whether a BSSID is one of the library's, either
+// from `network.bssids` or learned from its beacons.
+func IsLibraryBSSID(bssid string) bool {
+	bssid = strings.ToLower(bssid)
+	if bssid == "" {
+		return false
+	}
+	learnedMu.Lock()
+	learned := learnedBSSIDs[bssid]
+	learnedMu.Unlock()
+	if learned {
+		return true
+	}
+	for _, b := range GetLibraryBSSIDs() {
+		if b == bssid {
+			return true
+		}
+	}
+	return false
+}
+
+// onLibraryNetwork says whether a sighting shows a device using the
+// library's network: associating with, or sending data through, one of
+// its access points. Probe requests do not count; a phone in the
+// parking lot probes for every network it knows.
+func onLibraryNetwork(s structs.Sighting) bool {
+	if s.Class != structs.FrameAssoc && s.Class != structs.FrameData {
+		return false
+	}
+	// The MAC has been hashed by now, so hash the BSSID to compare.
+	return s.BSSID != "" && HashMAC(s.BSSID) != s.MAC && IsLibraryBSSID(s.BSSID)
+}
+
+func clearLearnedBSSIDs() {
+	learnedMu.Lock()
+	defer learnedMu.Unlock()
+	learnedBSSIDs = make(map[string]bool)
+}
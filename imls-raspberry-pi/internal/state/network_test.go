@@ -0,0 +1,66 @@
+package state
+
+import (
+	"testing"
+
+	"gsa.gov/18f/internal/structs"
+)
+
+func TestLibraryNetwork(t *testing.T) {
+	clearLearnedBSSIDs()
+	SetLibraryBSSIDs("00:11:22:33:44:55")
+	SetLibrarySSIDs("Library")
+	defer SetLibraryBSSIDs("")
+	defer SetLibrarySSIDs("")
+
+	if !IsLibraryBSSID("00:11:22:33:44:55") || IsLibraryBSSID("00:11:22:33:44:66") {
+		t.Error("expected the BSSIDs in network.bssids")
+	}
+	RecordBeacon("Coffee Shop", "00:11:22:33:44:77")
+	RecordBeacon("Library", "00:11:22:33:44:66")
+	if !IsLibraryBSSID("00:11:22:33:44:66") || IsLibraryBSSID("00:11:22:33:44:77") {
+		t.Error("expected to learn BSSIDs from the library's beacons only")
+	}
+	// A phone's hotspot, named for the library.
+	RecordBeacon("Library", "da:a1:19:00:00:01")
+	if IsLibraryBSSID("da:a1:19:00:00:01") {
+		t.Error("a BSSID from another manufacturer should not be learned")
+	}
+
+	for _, c := range []struct {
+		s    structs.Sighting
+		want bool
+	}{
+		{structs.Sighting{MAC: "f0:18:98:00:00:01", Class: structs.FrameData, BSSID: "00:11:22:33:44:55"}, true},
+		{structs.Sighting{MAC: "f0:18:98:00:00:01", Class: structs.FrameAssoc, BSSID: "00:11:22:33:44:66"}, true},
+		{structs.Sighting{MAC: "f0:18:98:00:00:01", Class: structs.FrameData, BSSID: "00:11:22:33:44:77"}, false},
+		{structs.Sighting{MAC: "f0:18:98:00:00:01", Class: structs.FrameProbe, BSSID: "00:11:22:33:44:55"}, false},
+		// The access point itself.
+		{structs.Sighting{MAC: "00:11:22:33:44:55", Class: structs.FrameData, BSSID: "00:11:22:33:44:55"}, false},
+	} {
+		if onLibraryNetwork(HashSighting(c.s)) != c.want {
+			t.Error("expected ", c.want, " for ", c.s)
+		}
+	}
+
+	ClearEphemeralDB()
+	if IsLibraryBSSID("00:11:22:33:44:66") || !IsLibraryBSSID("00:11:22:33:44:55") {
+		t.Error("learned BSSIDs should be forgotten at the reset")
+	}
+}
+
+func TestLibraryNetworkSSIDsOnly(t *testing.T) {
+	ClearEphemeralDB()
+	SetLibrarySSIDs("Library")
+	defer SetLibrarySSIDs("")
+
+	// With no BSSIDs to go on, the name is all there is.
+	RecordBeacon("Library", "da:a1:19:00:00:01")
+	if !IsLibraryBSSID("da:a1:19:00:00:01") {
+		t.Error("expected to learn the BSSID from the name alone")
+	}
+	ClearEphemeralDB()
+	if IsLibraryBSSID("da:a1:19:00:00:01") {
+		t.Error("learned BSSIDs should be forgotten at the reset")
+	}
+}
@@ -2,6 +2,7 @@ package state
 
 import (
 	"os"
+	"path/filepath"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -11,11 +12,7 @@ type QueueSuite struct {
 }
 
 func (suite *QueueSuite) SetupTest() {
-	temp, err := os.CreateTemp("", "queue-test.ini")
-	if err != nil {
-		suite.Fail(err.Error())
-	}
-	SetConfigAtPath(temp.Name())
+	SetConfigAtPath(filepath.Join(suite.T().TempDir(), "queue-test.ini"))
 }
 
 func (suite *QueueSuite) AfterTest(suiteName, testName string) {
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,7 +11,7 @@ type sessionId struct {
 }
 
 var (
-	// singleton pattern. not thread safe.
+	// singleton pattern. The id is only read and written atomically.
 	currentSession = sessionId{0}
 )
 
@@ -24,10 +26,40 @@ func NewSessionID() int64 {
 }
 
 func GetCurrentSessionID() int64 {
-	return currentSession.id
+	return atomic.LoadInt64(&currentSession.id)
 }
 
 func IncrementSessionID() int64 {
-	currentSession.id = NewSessionID()
-	return currentSession.id
+	id := NewSessionID()
+	atomic.StoreInt64(&currentSession.id, id)
+	return id
+}
+
+func setCurrentSessionID(id int64) {
+	atomic.StoreInt64(&currentSession.id, id)
+}
+
+// The capture gate makes a reset atomic. Captures hold it, shared, while
+// they store what they saw; a reset holds it alone, so it waits for
+// those in flight to finish, and new ones wait for the next session.
+var captureGate sync.RWMutex
+
+// StartCapture is called before storing a capture's sightings (and its
+// scan stats), and EndCapture after.
+func StartCapture() {
+	captureGate.RLock()
+}
+
+func EndCapture() {
+	captureGate.RUnlock()
+}
+
+// PauseCapture drains the captures storing sightings, and holds off any
+// more until ResumeCapture.
+func PauseCapture() {
+	captureGate.Lock()
+}
+
+func ResumeCapture() {
+	captureGate.Unlock()
 }
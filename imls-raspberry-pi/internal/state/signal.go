@@ -0,0 +1,96 @@
+package state
+
+import "sync"
+
+// A SignalSummary is what we keep of a device's signal strength over a
+// session. Readings are counted by whole dBm, which is all the radio
+// reports, so the median comes cheap without keeping every reading.
+type SignalSummary struct {
+	Count   int
+	Sum     int
+	Max     int
+	Buckets map[int]int
+}
+
+func (s SignalSummary) Mean() int {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / s.Count
+}
+
+// Median is the lower median of the readings, in dBm.
+func (s SignalSummary) Median() int {
+	seen := 0
+	// Readings run from about -100dBm up to 0.
+	for dbm := -128; dbm <= 127; dbm++ {
+		seen += s.Buckets[dbm]
+		if seen*2 >= s.Count && s.Count > 0 {
+			return dbm
+		}
+	}
+	return 0
+}
+
+// Per-MAC signal summaries for the current session. Cleared alongside
+// the ephemeral DB.
+var signals = make(map[string]*SignalSummary)
+
+// signalsMu guards signals.
+var signalsMu sync.Mutex
+
+// NOTE: Do not log MAC addresses.
+func RecordSignal(mac string, dbm int) {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+	s, ok := signals[mac]
+	if !ok {
+		s = &SignalSummary{Max: dbm, Buckets: make(map[int]int)}
+		signals[mac] = s
+	}
+	s.Count += 1
+	s.Sum += dbm
+	if dbm > s.Max {
+		s.Max = dbm
+	}
+	s.Buckets[dbm] += 1
+}
+
+func GetSignal(mac string) (SignalSummary, bool) {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+	s, ok := signals[mac]
+	if !ok {
+		return SignalSummary{}, false
+	}
+	summary := *s
+	// The buckets go on being counted into.
+	summary.Buckets = make(map[int]int)
+	for dbm, n := range s.Buckets {
+		summary.Buckets[dbm] = n
+	}
+	return summary, true
+}
+
+// SignalCounts says whether a sighting at `dbm` should count toward the
+// device's Duration. If it is below the `signal.floor`, or the device's
+// median so far is below `signal.min_median`, the sighting is dropped.
+// A zero dBm reading means the capture did not report one, and only the
+// median rule applies. Either rule is off when set to zero.
+func SignalCounts(mac string, dbm int) bool {
+	if floor := GetSignalFloor(); floor != 0 && dbm != 0 && dbm < floor {
+		return false
+	}
+	if minMedian := GetSignalMinMedian(); minMedian != 0 {
+		if s, ok := GetSignal(mac); ok && s.Median() < minMedian {
+			return false
+		}
+	}
+	return true
+}
+
+func clearSignals() {
+	signalsMu.Lock()
+	defer signalsMu.Unlock()
+	signals = make(map[string]*SignalSummary)
+}
@@ -0,0 +1,55 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestSignalSummary(t *testing.T) {
+	ClearEphemeralDB()
+	for _, dbm := range []int{-80, -60, -62, -90, -61} {
+		RecordSignal("DE:AD:BE:EF:00:00", dbm)
+	}
+	s, ok := GetSignal("DE:AD:BE:EF:00:00")
+	if !ok {
+		t.Fatal("no signal summary recorded")
+	}
+	if s.Count != 5 || s.Max != -60 || s.Mean() != -70 || s.Median() != -62 {
+		t.Error("bad summary: ", s, " mean ", s.Mean(), " median ", s.Median())
+	}
+	ClearEphemeralDB()
+	if _, ok := GetSignal("DE:AD:BE:EF:00:00"); ok {
+		t.Error("signal summary should be cleared with the ephemeral DB")
+	}
+}
+
+func TestSignalCounts(t *testing.T) {
+	ClearEphemeralDB()
+	defer SetSignalFloor(0)
+	defer SetSignalMinMedian(0)
+
+	SetSignalFloor(0)
+	SetSignalMinMedian(0)
+	if !SignalCounts("DE:AD:BE:EF:00:00", -95) {
+		t.Error("nothing should be dropped with the rules off")
+	}
+
+	SetSignalFloor(-75)
+	if SignalCounts("DE:AD:BE:EF:00:00", -80) {
+		t.Error("a sighting below the floor should be dropped")
+	}
+	if !SignalCounts("DE:AD:BE:EF:00:00", -70) || !SignalCounts("DE:AD:BE:EF:00:00", 0) {
+		t.Error("sightings at or above the floor, or without a signal, should count")
+	}
+
+	SetSignalFloor(0)
+	SetSignalMinMedian(-70)
+	for _, dbm := range []int{-60, -85, -85} {
+		RecordSignal("DE:AD:BE:EF:00:00", dbm)
+	}
+	if SignalCounts("DE:AD:BE:EF:00:00", -60) {
+		t.Error("a device with a weak median should be dropped")
+	}
+	if !SignalCounts("BE:EF:00:00:00:00", -60) {
+		t.Error("a device without a summary should count")
+	}
+}
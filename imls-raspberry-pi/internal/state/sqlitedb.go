@@ -3,8 +3,11 @@ package state
 import (
 	"fmt"
 	"log"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
@@ -12,23 +15,52 @@ import (
 )
 
 type SqliteDB struct {
-	Ptr    *sqlx.DB
-	Path   string
+	Ptr  *sqlx.DB
+	Path string
+	// The file SQLite works on: Path, or its decrypted working copy
+	// when encrypting at rest.
+	file   string
 	Tables map[string]*SqliteTable
+	// The tables CreateTableFromStruct has already created (and brought
+	// up to date) on this connection, and the structs they were made from.
+	created map[string]reflect.Type
+	// tablesMu guards Tables and created. A burst capture can still be
+	// recording its scan when the next one starts.
+	tablesMu sync.Mutex
 }
 
 var ptrCache map[string]*SqliteDB = make(map[string]*SqliteDB)
 
+// ptrMu guards ptrCache. Captures record their scans from their own
+// goroutines while the reset works on the same databases.
+var ptrMu sync.Mutex
+
+// cachedDBs is a snapshot of the open databases, to work through without
+// holding ptrMu.
+func cachedDBs() []*SqliteDB {
+	ptrMu.Lock()
+	defer ptrMu.Unlock()
+	dbs := make([]*SqliteDB, 0, len(ptrCache))
+	for _, db := range ptrCache {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
 func FlushCache() {
-	for _, ptr := range ptrCache {
+	for _, ptr := range cachedDBs() {
 		ptr.Close()
 	}
+	ptrMu.Lock()
 	ptrCache = make(map[string]*SqliteDB)
+	ptrMu.Unlock()
 }
 
 func NewSqliteDB(path string) *SqliteDB {
 	var db *SqliteDB
 
+	ptrMu.Lock()
+	defer ptrMu.Unlock()
 	if ptr, ok := ptrCache[path]; ok {
 		db = ptr
 	} else {
@@ -36,6 +68,10 @@ func NewSqliteDB(path string) *SqliteDB {
 		// cfg.Log().Debug("opening db at " + path)
 		db = &SqliteDB{}
 		db.Path = path
+		db.file = path
+		if IsEncryptingAtRest() && !strings.Contains(path, "memory") {
+			db.file = workingPath(path)
+		}
 		db.Ptr = nil
 		//db.Tables = make(map[string]map[string]string)
 		db.Tables = make(map[string]*SqliteTable)
@@ -48,7 +84,12 @@ func NewSqliteDB(path string) *SqliteDB {
 func (db *SqliteDB) Open() {
 	// cfg := GetConfig()
 	if db.Ptr == nil {
-		ptr, err := sqlx.Open("sqlite3", db.Path+"?mode=rwc")
+		if db.sealed() {
+			if err := unseal(db.Path, db.file); err != nil {
+				log.Panic("could not unseal db ", err)
+			}
+		}
+		ptr, err := sqlx.Open("sqlite3", db.file+"?mode=rwc")
 		if err != nil {
 			// cfg.Log().Error("could not open db: ", db.Path)
 			// cfg.Log().Fatal(err.Error())
@@ -64,7 +105,9 @@ func (db *SqliteDB) Close() {
 	if strings.Contains(db.Path, "memory") {
 		// Do nothing. Keep memory DB open.
 	} else {
+		ptrMu.Lock()
 		delete(ptrCache, db.Path) // clear db cache, we are explicitly closing
+		ptrMu.Unlock()
 		if db.Ptr != nil {
 			//lw.Debug("closing db: ", tdb.DBName)
 			err := db.Ptr.Close()
@@ -72,10 +115,41 @@ func (db *SqliteDB) Close() {
 				log.Panic("could not close db [", db.Path, "]")
 			}
 			db.Ptr = nil
+			if db.sealed() {
+				// If it cannot be sealed, the working copy is all we
+				// have; it is kept, and picked up when next opened.
+				if err := seal(db.Path, db.file); err != nil {
+					log.Println("could not seal db [", db.Path, "]; keeping the working copy ", err)
+				} else {
+					// Nothing decrypted is left behind.
+					os.Remove(db.file)
+				}
+			}
 		}
 	}
 }
 
+// sealed says whether the database is encrypted at rest.
+func (db *SqliteDB) sealed() bool {
+	return db.file != db.Path
+}
+
+// Seal writes an encrypted copy of an open database back to its path.
+// It does nothing for a database that is not encrypted at rest.
+func (db *SqliteDB) Seal() error {
+	if !db.sealed() || db.Ptr == nil {
+		return nil
+	}
+	// Seal a consistent snapshot, not a file SQLite may be writing to.
+	snapshot := db.file + ".snapshot"
+	os.Remove(snapshot)
+	if _, err := db.Ptr.Exec("VACUUM INTO ?", snapshot); err != nil {
+		return err
+	}
+	defer os.Remove(snapshot)
+	return seal(db.Path, snapshot)
+}
+
 func (db *SqliteDB) GetPtr() *sqlx.DB {
 	return db.Ptr
 }
@@ -84,6 +158,7 @@ func (db *SqliteDB) GetPath() string {
 	return db.Path
 }
 
+// initTable is called with tablesMu held.
 func (db *SqliteDB) initTable(name string) *SqliteTable {
 	if tptr, ok := db.Tables[name]; ok {
 		return tptr
@@ -98,18 +173,29 @@ func (db *SqliteDB) initTable(name string) *SqliteTable {
 }
 
 func (db *SqliteDB) InitTable(name string) interfaces.Table {
+	db.tablesMu.Lock()
+	defer db.tablesMu.Unlock()
 	t := db.initTable(name)
 	return t
 }
 
 func (db *SqliteDB) RemoveTable(name string) {
+	db.tablesMu.Lock()
+	defer db.tablesMu.Unlock()
 	delete(db.Tables, name)
+	delete(db.created, name)
 }
 
 func (db *SqliteDB) CreateTableFromStruct(s interface{}) interfaces.Table {
 	//columns := make(map[string]string)
 	name := reflect.TypeOf(s).Name()
+	// Held while the table is made, so it is only made once.
+	db.tablesMu.Lock()
+	defer db.tablesMu.Unlock()
 	t := db.initTable(name + "s")
+	if db.created[t.Name] == reflect.TypeOf(s) {
+		return t
+	}
 	ct := make(map[string]string)
 
 	rt := reflect.TypeOf(s)
@@ -140,16 +226,57 @@ func (db *SqliteDB) CreateTableFromStruct(s interface{}) interfaces.Table {
 	if err != nil {
 		log.Fatalf("Failed to create table from struct: " + t.Name + " in " + db.Path)
 	}
+	db.addMissingColumns(t.Name, ct)
+	if db.created == nil {
+		db.created = make(map[string]reflect.Type)
+	}
+	db.created[t.Name] = reflect.TypeOf(s)
 
 	return t
 }
 
+// addMissingColumns brings a table created by an older version of the
+// struct up to date. New columns get a zero default, so that rows written
+// before the column existed still scan into the struct.
+func (db *SqliteDB) addMissingColumns(table string, ct map[string]string) {
+	rows, err := db.Ptr.Queryx(fmt.Sprintf("PRAGMA table_info(%v)", table))
+	if err != nil {
+		log.Fatalf("Failed to read columns of " + table + " in " + db.Path)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		col, err := rows.SliceScan()
+		if err != nil {
+			log.Fatalf("Failed to read columns of " + table + " in " + db.Path)
+		}
+		// cid, name, type, notnull, dflt_value, pk
+		existing[fmt.Sprint(col[1])] = true
+	}
+	rows.Close()
+
+	for c, tpe := range ct {
+		if existing[c] || strings.Contains(tpe, "PRIMARY KEY") {
+			continue
+		}
+		zero := "''"
+		if strings.HasPrefix(tpe, "INTEGER") {
+			zero = "0"
+		}
+		stmnt := fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v DEFAULT %v", table, c, tpe, zero)
+		if _, err := db.Ptr.Exec(stmnt); err != nil {
+			log.Fatalf("Failed to add column " + c + " to " + table + " in " + db.Path)
+		}
+	}
+}
+
 func (db *SqliteDB) CheckTableExists(name string) bool {
 	_, tableCheck := db.Ptr.Query("select * from " + name + ";")
 	return tableCheck == nil
 }
 
 func (db *SqliteDB) ListTables() []string {
+	db.tablesMu.Lock()
+	defer db.tablesMu.Unlock()
 	names := make([]string, 0)
 	for name := range db.Tables {
 		names = append(names, name)
@@ -159,12 +286,13 @@ func (db *SqliteDB) ListTables() []string {
 
 func (db *SqliteDB) GetTableFromStruct(s interface{}) interfaces.Table {
 	name := reflect.TypeOf(s).Name()
-	db.InitTable(name)
 	// cfg.Log().Debug(db.Tables)
-	return db.Tables[name]
+	return db.InitTable(name)
 }
 
 func (db *SqliteDB) GetTableByName(name string) interfaces.Table {
+	db.tablesMu.Lock()
+	defer db.tablesMu.Unlock()
 	return db.Tables[name]
 }
 
@@ -178,14 +306,20 @@ type SqliteTable struct {
 	Name            string
 	DB              interfaces.Database
 	ColumnsAndTypes map[string]string
+	// columnsMu guards ColumnsAndTypes.
+	columnsMu sync.Mutex
 }
 
 func (t *SqliteTable) AddColumn(name string, sqlitetype string) {
 	// log.Println("adding column " + name + " type " + sqlitetype)
+	t.columnsMu.Lock()
+	defer t.columnsMu.Unlock()
 	t.ColumnsAndTypes[name] = sqlitetype
 }
 
 func (t *SqliteTable) Create() {
+	t.columnsMu.Lock()
+	defer t.columnsMu.Unlock()
 	cols := make([]string, 0)
 	for c, t := range t.ColumnsAndTypes {
 		cols = append(cols, fmt.Sprintf("%v %v", c, t))
@@ -221,6 +355,8 @@ func insertQueryBuilder(s interface{}) string {
 					values = append(values, fmt.Sprint(v.Field(i).Int()))
 				case reflect.String:
 					values = append(values, fmt.Sprintf("\"%v\"", v.Field(i).String()))
+				case reflect.Float64:
+					values = append(values, strconv.FormatFloat(v.Field(i).Float(), 'g', -1, 64))
 				default:
 					log.Fatal("insertquerybuilder: unsupported field type in " + name)
 
@@ -3,6 +3,7 @@ package state
 import (
 	"log"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -108,3 +109,58 @@ func TestManyOpens(test *testing.T) {
 		d.CreateTableFromStruct(Apple{})
 	}
 }
+
+func TestAddMissingColumns(test *testing.T) {
+	tempDB, err := os.CreateTemp("", "sqlitedb-test-add-columns")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tempDB.Name())
+	d := NewSqliteDB(tempDB.Name())
+	d.CreateTableFromStruct(Apple{}).InsertStruct(Apple{Color: "red", Weight: 3})
+
+	// The same table, from a newer version of the struct.
+	type Apple struct {
+		Color   string `db:"color" type:"TEXT"`
+		Weight  int    `db:"weight" type:"INTEGER"`
+		Variety string `db:"variety" type:"TEXT"`
+		Seeds   int    `db:"seeds" type:"INTEGER"`
+	}
+	d.CreateTableFromStruct(Apple{}).InsertStruct(Apple{Color: "green", Weight: 5, Variety: "granny smith", Seeds: 8})
+
+	apples := []Apple{}
+	err = d.GetPtr().Select(&apples, "SELECT * FROM Apples ORDER BY weight")
+	if err != nil {
+		test.Fatal("could not read back old rows: ", err)
+	}
+	if len(apples) != 2 || apples[0].Variety != "" || apples[1].Seeds != 8 {
+		test.Error("unexpected apples ", apples)
+	}
+}
+
+func TestConcurrentOpens(test *testing.T) {
+	tempDB, err := os.CreateTemp("", "sqlitedb-test-concurrent")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tempDB.Name())
+	NewSqliteDB(tempDB.Name()).CreateTableFromStruct(Apple{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				NewSqliteDB(tempDB.Name()).GetTableFromStruct(Apple{}).InsertStruct(Apple{Color: "red", Weight: j})
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		SealDatabases()
+	}
+	wg.Wait()
+	if n := len(Apple{}.SelectAll(NewSqliteDB(tempDB.Name()))); n != 200 {
+		test.Error("expected 200 apples, found ", n)
+	}
+	FlushCache()
+}
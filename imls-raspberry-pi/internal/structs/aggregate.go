@@ -0,0 +1,66 @@
+package structs
+
+// Aggregate is a count published with differential-privacy noise: the
+// devices seen in one bucket (an hour, or the whole session). The noise
+// parameters travel with the count, so anyone using it knows how far to
+// trust it.
+type Aggregate struct {
+	ID        int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	PiSerial  string `json:"pi_serial" db:"pi_serial" type:"TEXT"`
+	SessionID string `json:"session_id" db:"session_id" type:"TEXT"`
+	FCFSSeqID string `json:"fcfs_seq_id" db:"fcfs_seq_id" type:"TEXT"`
+	DeviceTag string `json:"device_tag" db:"device_tag" type:"TEXT"`
+	// One of the Period* constants.
+	Period string `json:"period" db:"period" type:"TEXT"`
+	// The start of the bucket, in UNIX epoch seconds, and its length.
+	Start   int64 `json:"start" db:"start" type:"INTEGER"`
+	Seconds int   `json:"seconds" db:"seconds" type:"INTEGER"`
+	// The noisy count, rounded and never below zero.
+	Count int `json:"count" db:"count" type:"INTEGER"`
+	// "laplace" or "gaussian", with the budget spent on this statistic
+	// and the scale of the noise (b for Laplace, sigma for Gaussian).
+	Mechanism   string  `json:"mechanism" db:"mechanism" type:"TEXT"`
+	Epsilon     float64 `json:"epsilon" db:"epsilon" type:"REAL"`
+	Delta       float64 `json:"delta" db:"delta" type:"REAL"`
+	Sensitivity float64 `json:"sensitivity" db:"sensitivity" type:"REAL"`
+	Scale       float64 `json:"scale" db:"scale" type:"REAL"`
+}
+
+// Aggregate periods.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+func (a Aggregate) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"pi_serial":   a.PiSerial,
+		"session_id":  a.SessionID,
+		"fcfs_seq_id": a.FCFSSeqID,
+		"device_tag":  a.DeviceTag,
+		"period":      a.Period,
+		"start":       a.Start,
+		"seconds":     a.Seconds,
+		"count":       a.Count,
+		"mechanism":   a.Mechanism,
+		"epsilon":     a.Epsilon,
+		"delta":       a.Delta,
+		"sensitivity": a.Sensitivity,
+		"scale":       a.Scale,
+	}
+}
+
+// PrivacySpend is one line of the privacy budget ledger: the budget a
+// statistic spent against a session. Every device is in exactly one
+// session, so a session's total is what any one device has given up.
+type PrivacySpend struct {
+	ID          int     `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	SessionID   string  `json:"session_id" db:"session_id" type:"TEXT"`
+	Statistic   string  `json:"statistic" db:"statistic" type:"TEXT"`
+	Mechanism   string  `json:"mechanism" db:"mechanism" type:"TEXT"`
+	Epsilon     float64 `json:"epsilon" db:"epsilon" type:"REAL"`
+	Delta       float64 `json:"delta" db:"delta" type:"REAL"`
+	Sensitivity float64 `json:"sensitivity" db:"sensitivity" type:"REAL"`
+	// When the budget was spent, in UNIX epoch seconds.
+	Timestamp int64 `json:"timestamp" db:"timestamp" type:"INTEGER"`
+}
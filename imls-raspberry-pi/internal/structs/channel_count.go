@@ -0,0 +1,14 @@
+package structs
+
+// ChannelCount is one row per channel per session: how many frames we
+// saw on the channel, and from how many distinct devices.
+type ChannelCount struct {
+	ID        int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	PiSerial  string `json:"pi_serial" db:"pi_serial" type:"TEXT"`
+	SessionID string `json:"session_id" db:"session_id" type:"TEXT"`
+	FCFSSeqID string `json:"fcfs_seq_id" db:"fcfs_seq_id" type:"TEXT"`
+	DeviceTag string `json:"device_tag" db:"device_tag" type:"TEXT"`
+	Channel   int    `json:"channel" db:"channel" type:"INTEGER"`
+	Sightings int    `json:"sightings" db:"sightings" type:"INTEGER"`
+	Devices   int    `json:"devices" db:"devices" type:"INTEGER"`
+}
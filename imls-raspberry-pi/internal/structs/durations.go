@@ -30,6 +30,18 @@ type Duration struct {
 	PatronID  int    `json:"patron_index" db:"patron_index" type:"INTEGER"`
 	Start     int64  `json:"start,string" db:"start" type:"INTEGER"`
 	End       int64  `json:"end,string" db:"end" type:"INTEGER"`
+	// One of the Client* classes; empty if the capture did not say.
+	ClientClass string `json:"client_class" db:"client_class" type:"TEXT"`
+	// 1 if the device used a randomized MAC, 0 if a global one.
+	Randomized int `json:"randomized" db:"randomized" type:"INTEGER"`
+	// One of the oui categories: phone, computer, and so on.
+	ManufacturerIndex int `json:"manufacturer_index" db:"manufacturer_index" type:"INTEGER"`
+	// 1 if the device was seen using the library's own network.
+	Library int `json:"library" db:"library" type:"INTEGER"`
+	// The resolution of Start and End, in seconds; 1 if they are exact.
+	Granularity int `json:"granularity" db:"granularity" type:"INTEGER"`
+	// 1 if a reset cut the visit short, at either end.
+	Truncated int `json:"truncated" db:"truncated" type:"INTEGER"`
 }
 
 func (d Duration) AsMap() map[string]interface{} {
@@ -10,8 +10,8 @@ func TestAsMapDuration(t *testing.T) {
 		// ID:        1,
 		PiSerial:  "asdf",
 		DeviceTag: "abd-dc",
-		Start:     time.Now().Format(time.RFC3339),
-		End:       time.Now().Format(time.RFC3339),
+		Start:     time.Now().Unix(),
+		End:       time.Now().Unix(),
 		SessionID: "hello",
 		PatronID:  0,
 	}
@@ -0,0 +1,15 @@
+package structs
+
+// NetworkTotal is one row per session: how many devices we saw in all,
+// and how many of them used the library's own network. Both are kept
+// whatever the `network.mode`, so the two can be compared.
+type NetworkTotal struct {
+	ID             int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	PiSerial       string `json:"pi_serial" db:"pi_serial" type:"TEXT"`
+	SessionID      string `json:"session_id" db:"session_id" type:"TEXT"`
+	FCFSSeqID      string `json:"fcfs_seq_id" db:"fcfs_seq_id" type:"TEXT"`
+	DeviceTag      string `json:"device_tag" db:"device_tag" type:"TEXT"`
+	NetworkMode    string `json:"network_mode" db:"network_mode" type:"TEXT"`
+	AllDevices     int    `json:"all_devices" db:"all_devices" type:"INTEGER"`
+	LibraryDevices int    `json:"library_devices" db:"library_devices" type:"INTEGER"`
+}
@@ -0,0 +1,33 @@
+package structs
+
+// What an Upload was.
+const (
+	UploadDurations  = "durations"
+	UploadAggregates = "aggregates"
+	UploadCoverage   = "coverage"
+)
+
+// Upload records that one kind of a session's rows was confirmed
+// received by the API. Rows are only purged once they were uploaded.
+type Upload struct {
+	ID        int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	SessionID string `json:"session_id" db:"session_id" type:"TEXT"`
+	// One of the Upload* kinds. Blank for uploads recorded before there
+	// were kinds, which were of the whole session.
+	Kind string `json:"kind" db:"kind" type:"TEXT"`
+	// When the upload went through, in UNIX epoch seconds.
+	Timestamp int64 `json:"timestamp" db:"timestamp" type:"INTEGER"`
+}
+
+// Purge records what the retention policy deleted: rows from a table,
+// or, for PurgedImages, image files.
+type Purge struct {
+	ID        int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	SessionID string `json:"session_id" db:"session_id" type:"TEXT"`
+	Table     string `json:"table_name" db:"table_name" type:"TEXT"`
+	Rows      int    `json:"rows" db:"rows" type:"INTEGER"`
+	// When the purge ran, in UNIX epoch seconds.
+	Timestamp int64 `json:"timestamp" db:"timestamp" type:"INTEGER"`
+}
+
+const PurgedImages = "images"
@@ -0,0 +1,69 @@
+package structs
+
+// ScanStat is one row per capture: one SimpleShark run on one adapter,
+// or one streamed batch. A day with no durations and no scans is a dead
+// sensor, not an empty library.
+type ScanStat struct {
+	ID        int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	PiSerial  string `json:"pi_serial" db:"pi_serial" type:"TEXT"`
+	SessionID string `json:"session_id" db:"session_id" type:"TEXT"`
+	FCFSSeqID string `json:"fcfs_seq_id" db:"fcfs_seq_id" type:"TEXT"`
+	DeviceTag string `json:"device_tag" db:"device_tag" type:"TEXT"`
+	// When the capture started, in UNIX epoch seconds.
+	Timestamp int64 `json:"timestamp" db:"timestamp" type:"INTEGER"`
+	// Empty when no adapter was found to capture on.
+	Adapter string `json:"adapter" db:"adapter" type:"TEXT"`
+	// Frames with a source address, and how many distinct addresses.
+	Frames  int `json:"frames" db:"frames" type:"INTEGER"`
+	Devices int `json:"devices" db:"devices" type:"INTEGER"`
+	Seconds int `json:"seconds" db:"seconds" type:"INTEGER"`
+	// ScanOK, or the kind of failure.
+	Outcome string `json:"outcome" db:"outcome" type:"TEXT"`
+}
+
+const ScanOK = "ok"
+
+// Coverage summarizes a session's ScanStats, and is uploaded alongside
+// its durations. It also records what small-count protection held back
+// from them.
+type Coverage struct {
+	ID             int    `json:"id" db:"id" type:"INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL"`
+	PiSerial       string `json:"pi_serial" db:"pi_serial" type:"TEXT"`
+	SessionID      string `json:"session_id" db:"session_id" type:"TEXT"`
+	FCFSSeqID      string `json:"fcfs_seq_id" db:"fcfs_seq_id" type:"TEXT"`
+	DeviceTag      string `json:"device_tag" db:"device_tag" type:"TEXT"`
+	Scans          int    `json:"scans" db:"scans" type:"INTEGER"`
+	FailedScans    int    `json:"failed_scans" db:"failed_scans" type:"INTEGER"`
+	CaptureSeconds int    `json:"capture_seconds" db:"capture_seconds" type:"INTEGER"`
+	Frames         int    `json:"frames" db:"frames" type:"INTEGER"`
+	Adapters       int    `json:"adapters" db:"adapters" type:"INTEGER"`
+	// The first and last successful scans, in UNIX epoch seconds; zero
+	// if there were none.
+	FirstScan int64 `json:"first_scan" db:"first_scan" type:"INTEGER"`
+	LastScan  int64 `json:"last_scan" db:"last_scan" type:"INTEGER"`
+	// The `privacy.k` in force, how many durations were dropped, and how
+	// many hourly buckets were merged into a neighbour.
+	KThreshold          int `json:"k_threshold" db:"k_threshold" type:"INTEGER"`
+	SuppressedDurations int `json:"suppressed_durations" db:"suppressed_durations" type:"INTEGER"`
+	MergedBuckets       int `json:"merged_buckets" db:"merged_buckets" type:"INTEGER"`
+}
+
+func (c Coverage) AsMap() map[string]interface{} {
+	return map[string]interface{}{
+		"pi_serial":       c.PiSerial,
+		"session_id":      c.SessionID,
+		"fcfs_seq_id":     c.FCFSSeqID,
+		"device_tag":      c.DeviceTag,
+		"scans":           c.Scans,
+		"failed_scans":    c.FailedScans,
+		"capture_seconds": c.CaptureSeconds,
+		"frames":          c.Frames,
+		"adapters":        c.Adapters,
+		"first_scan":      c.FirstScan,
+		"last_scan":       c.LastScan,
+		// What small-count protection held back.
+		"k_threshold":          c.KThreshold,
+		"suppressed_durations": c.SuppressedDurations,
+		"merged_buckets":       c.MergedBuckets,
+	}
+}
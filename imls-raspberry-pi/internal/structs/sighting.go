@@ -0,0 +1,74 @@
+package structs
+
+import "strconv"
+
+// A Sighting is one frame's worth of what the capture backends tell us
+// about a device. Only MAC is guaranteed to be filled in.
+type Sighting struct {
+	MAC string
+	// Channel the frame was received on, or zero if unknown.
+	Channel int
+	// Adapter the frame was captured on, or empty if unknown.
+	Adapter string
+	// Signal strength in dBm, or zero if unknown.
+	Signal int
+	// Class is one of the Frame* classes below, or empty if unknown.
+	Class string
+	// Randomized is set for locally administered addresses, which is
+	// what phones use when they rotate their MAC.
+	Randomized bool
+	// Fingerprint is a keyed hash of a probe request's information
+	// elements, if fingerprinting is on. The elements themselves are
+	// never kept.
+	Fingerprint string
+	// BSSID is the network the frame was sent on, or empty if unknown.
+	BSSID string
+	// SSID is the network name, for beacons and probe responses only.
+	SSID string
+	// Manufacturer is the oui category of the MAC, looked up before the
+	// MAC is hashed.
+	Manufacturer int
+}
+
+// IsRandomized reports whether a MAC address has the locally
+// administered bit set in its first octet.
+func IsRandomized(mac string) bool {
+	if len(mac) < 2 {
+		return false
+	}
+	octet, err := strconv.ParseUint(mac[:2], 16, 8)
+	return err == nil && octet&0x02 != 0
+}
+
+// Frame classes, from a frame's type, subtype, and direction.
+const (
+	// Probe requests.
+	FrameProbe = "probe"
+	// (Re)association and authentication requests.
+	FrameAssoc = "assoc"
+	// Data frames a client sends to the network.
+	FrameData = "data"
+	// Everything else: beacons, data from the network to clients, and
+	// so on. Mostly infrastructure.
+	FrameOther = "other"
+)
+
+// How a device is reported in a Duration, from the frames we saw it send.
+const (
+	ClientAssociated = "associated"
+	ClientProbe      = "probe"
+	ClientOther      = "other"
+)
+
+// ClientClass reports a device by the most telling frame class it sent.
+func ClientClass(frameClass string) string {
+	switch frameClass {
+	case FrameAssoc, FrameData:
+		return ClientAssociated
+	case FrameProbe:
+		return ClientProbe
+	case FrameOther:
+		return ClientOther
+	}
+	return ""
+}
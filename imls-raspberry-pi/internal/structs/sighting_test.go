@@ -0,0 +1,17 @@
+package structs
+
+import "testing"
+
+func TestIsRandomized(t *testing.T) {
+	for mac, expected := range map[string]bool{
+		"f0:18:98:00:00:01": false,
+		"da:a1:19:00:00:01": true,
+		"6E:00:00:00:00:02": true,
+		"00:11:22:33:44:55": false,
+		"":                  false,
+	} {
+		if IsRandomized(mac) != expected {
+			t.Error(mac, " should have randomized ", expected)
+		}
+	}
+}
@@ -18,12 +18,16 @@ func GetDeviceHash(wlan *models.Device) []map[string]string {
 
 	cmd := exec.Command(state.GetLshwPath(), "-class", "network")
 	stdout, err := cmd.StdoutPipe()
+	// Without lshw we find no devices, and the capture supervisor
+	// backs off and tries again.
 	if err != nil {
-		log.Fatal().Err(err).Msg("cpw: cannot get stdout from lshw")
+		log.Error().Err(err).Msg("cpw: cannot get stdout from lshw")
+		return []map[string]string{}
 	}
 
 	if err := cmd.Start(); err != nil {
-		log.Fatal().Err(err).Msg("cpw: cannot start lshw")
+		log.Error().Err(err).Msg("cpw: cannot start lshw")
+		return []map[string]string{}
 	}
 
 	arr := make([]string, 0)
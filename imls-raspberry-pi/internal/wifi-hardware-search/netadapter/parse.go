@@ -51,7 +51,8 @@ func (p *PowerShell) Execute(args ...string) []byte {
 	cmd.Stdout = &out
 	cmd.Stderr = nil
 	if err := cmd.Run(); err != nil {
-		log.Fatal().Err(err).Msg("Powershell: cannot start command")
+		log.Error().Err(err).Msg("Powershell: cannot start command")
+		return []byte{}
 	}
 	return out.Bytes()
 }
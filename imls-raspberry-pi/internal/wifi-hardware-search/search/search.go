@@ -3,6 +3,7 @@ package search
 import (
 	"embed"
 	"encoding/json"
+	"fmt"
 	"os/exec"
 	"regexp"
 	"runtime"
@@ -46,7 +47,10 @@ func GetSearches() []models.Search {
 	return searches
 }
 
-func SetMonitorMode(dev *models.Device) {
+// PURPOSE
+// Puts an adapter into monitor mode. A failure leaves the adapter as it
+// was; the capture supervisor decides when to try again.
+func SetMonitorMode(dev *models.Device) error {
 	cmds := make([]*exec.Cmd, 0)
 	if runtime.GOOS == "windows" {
 		cmds = append(cmds, exec.Command(state.GetWlanHelperPath(), dev.Logicalname, "mode", "monitor"))
@@ -57,21 +61,39 @@ func SetMonitorMode(dev *models.Device) {
 	}
 	// Run the commands to set the adapter into monitor mode.
 	for _, c := range cmds {
-		err := c.Start()
+		out, err := c.CombinedOutput()
 		if err != nil {
 			log.Error().
 				Err(err).
 				Str("command", c.String()).
-				Msg("command did not execute")
-		}
-		err = c.Wait()
-		if err != nil {
-			log.Fatal().
-				Err(err).
-				Str("command", c.String()).
+				Str("output", strings.TrimSpace(string(out))).
 				Msg("command failed")
+			return fmt.Errorf("%s: %w: %s", c.String(), err, strings.TrimSpace(string(out)))
 		}
 	}
+	return nil
+}
+
+// PURPOSE
+// Tunes an adapter that is already in monitor mode to a channel.
+// Unlike SetMonitorMode, a failure here is not fatal; the channel
+// hopper will try again on the next channel.
+func SetChannel(dev *models.Device, channel int) error {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command(state.GetWlanHelperPath(), dev.Logicalname, "channel", strconv.Itoa(channel))
+	} else {
+		c = exec.Command(state.GetIwPath(), dev.Logicalname, "set", "channel", strconv.Itoa(channel))
+	}
+	out, err := c.CombinedOutput()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("command", c.String()).
+			Str("output", strings.TrimSpace(string(out))).
+			Msg("could not set channel")
+	}
+	return err
 }
 
 // PURPOSE
@@ -99,76 +121,111 @@ func osFindMatchingDevice(wlan *models.Device) []map[string]string {
 	}
 }
 
+// PURPOSE
+// Says whether one device hash from lshw (or netadapter) matches a search.
+func hashMatches(search *models.Search, hash map[string]string) bool {
+	// The default is to search all the fields
+	if search.Field == "ALL" {
+		log.Debug().
+			Str("query", search.Query).
+			Str("field", search.Field).
+			Msg("searching all fields")
+		for k := range hash {
+			// Lowercase everything for purposes of pattern matching.
+			if v, _ := regexp.MatchString(strings.ToLower(search.Query), strings.ToLower(hash[k])); v {
+				return true
+			}
+		}
+		return false
+	}
+	// If we aren't doing a full search, then this is the alternative: check just
+	// one field. It will still be a lowercase search, but it will be against one field only.
+	log.Debug().
+		Str("query", search.Query).
+		Str("field", search.Field).
+		Msg("searching one field")
+	v, _ := regexp.MatchString(strings.ToLower(search.Query), strings.ToLower(hash[search.Field]))
+	return v
+}
+
+// PURPOSE
+// Copies the fields we use out of a matching device hash.
+func fillDevice(wlan *models.Device, hash map[string]string) {
+	wlan.Exists = true
+	wlan.Vendor = strings.ToLower(hash["vendor"])
+	wlan.Physicalid, _ = strconv.Atoi(hash["physical id"])
+	wlan.Description = strings.ToLower(hash["description"])
+	wlan.Businfo = strings.ToLower(hash["bus info"])
+	wlan.Logicalname = strings.ToLower(hash["logical name"])
+	wlan.Serial = strings.ToLower(hash["serial"])
+
+	if len(hash["serial"]) >= MACLENGTH {
+		wlan.Mac = strings.ToLower(hash["serial"][0:MACLENGTH])
+	} else {
+		wlan.Mac = strings.ToLower(hash["serial"])
+	}
+	wlan.Configuration = strings.ToLower(hash["configuration"])
+}
+
+func logHash(hash map[string]string) {
+	log.Debug().Msg("--------")
+	for k, v := range hash {
+		log.Debug().Str("key", k).Str("value", v).Msg("looking at field")
+	}
+}
+
 // PURPOSE
 // Takes a Device structure and, using the Search fields of that structure,
 // attempts to find a matching WLAN device.
 func FindMatchingDevice(wlan *models.Device) {
 	devices := osFindMatchingDevice(wlan)
 
-	// We start by assuming that we have not found the device.
-	found := false
-
 	// Now, go through the devices and find the one that matches our criteria.
+	// Only keep the first thing we find. Back in 'main', we'll handle the
+	// case where wlan.exists is false.
 	for _, hash := range devices {
-		log.Debug().Msg("--------")
-		for k, v := range hash {
-			log.Debug().Str("key", k).Str("value", v).Msg("looking at field")
+		logHash(hash)
+		if hashMatches(wlan.Search, hash) {
+			fillDevice(wlan, hash)
+			break
 		}
+	}
+}
 
-		// The default is to search all the fields
-		if wlan.Search.Field == "ALL" {
-
-			for k := range hash {
-				// Lowercase everything for purposes of pattern matching.
-				v, _ := regexp.MatchString(strings.ToLower(wlan.Search.Query), strings.ToLower(hash[k]))
-				log.Debug().
-					Str("query", wlan.Search.Query).
-					Str("field", wlan.Search.Field).
-					Msg("searching all fields")
-				if v {
-					// If we find it, set the fact that it exists. This will be picked up
-					// back out in main() for the final act of printing a message to the user.
-					wlan.Exists = true
-				}
-				// Stop searching if we find something.
-				if wlan.Exists {
-					break
-				}
-			}
-		} else {
-			// If we aren't doing a full search, then this is the alternative: check just
-			// one field. It will still be a lowercase search, but it will be against one field only.
-			log.Debug().
-				Str("query", wlan.Search.Query).
-				Str("field", wlan.Search.Field).
-				Msg("searching one field")
-			v, _ := regexp.MatchString(strings.ToLower(wlan.Search.Query), strings.ToLower(hash[wlan.Search.Field]))
-			if v {
-				wlan.Exists = true
-			}
+// PURPOSE
+// Like FindMatchingDevice, but returns every device matching the search.
+func FindMatchingDevices(search *models.Search) []*models.Device {
+	found := make([]*models.Device, 0)
+	for _, hash := range osFindMatchingDevice(&models.Device{Search: search}) {
+		logHash(hash)
+		if hashMatches(search, hash) {
+			dev := &models.Device{Search: search}
+			fillDevice(dev, hash)
+			found = append(found, dev)
 		}
+	}
+	return found
+}
 
-		// If we find something, proceed. But only keep the first thing we find.
-		// Back in 'main', we'll handle the case where wlan.exists is false.
-		if wlan.Exists && !found {
-			found = true
-			wlan.Vendor = strings.ToLower(hash["vendor"])
-			wlan.Physicalid, _ = strconv.Atoi(hash["physical id"])
-			wlan.Description = strings.ToLower(hash["description"])
-			wlan.Businfo = strings.ToLower(hash["bus info"])
-			wlan.Logicalname = strings.ToLower(hash["logical name"])
-			wlan.Serial = strings.ToLower(hash["serial"])
-
-			if len(hash["serial"]) >= MACLENGTH {
-				wlan.Mac = strings.ToLower(hash["serial"][0:MACLENGTH])
-			} else {
-				wlan.Mac = strings.ToLower(hash["serial"])
+// PURPOSE
+// Find every matching device, up to `capture.adapters` of them. Devices
+// matched by more than one search are only returned once.
+func SearchForMatchingDevices() []*models.Device {
+	devices := make([]*models.Device, 0)
+	seen := make(map[string]bool)
+	limit := state.GetCaptureAdapters()
+	for _, s := range GetSearches() {
+		s := s
+		for _, dev := range FindMatchingDevices(&s) {
+			if seen[dev.Logicalname] {
+				continue
 			}
-			wlan.Configuration = strings.ToLower(hash["configuration"])
-
-			// Once we populate something in this loop, break out.
-			// This will return us to the caller with a populated structure.
-			break
+			if limit > 0 && len(devices) >= limit {
+				return devices
+			}
+			seen[dev.Logicalname] = true
+			devices = append(devices, dev)
 		}
 	}
+	return devices
 }
@@ -2,6 +2,8 @@ package search
 
 import (
 	"testing"
+
+	"gsa.gov/18f/internal/wifi-hardware-search/models"
 )
 
 func TestGetSearchStrings(t *testing.T) {
@@ -29,3 +31,31 @@ func TestGetSearchStrings(t *testing.T) {
 	// 	}
 	// }
 }
+
+func TestHashMatches(t *testing.T) {
+	hash := map[string]string{
+		"description":  "Wireless interface",
+		"vendor":       "Ralink Technology, Corp.",
+		"logical name": "wlan1",
+		"serial":       "00:c0:ca:00:00:01",
+	}
+	for _, c := range []struct {
+		search models.Search
+		want   bool
+	}{
+		{models.Search{Field: "ALL", Query: "ralink"}, true},
+		{models.Search{Field: "vendor", Query: "ralink"}, true},
+		{models.Search{Field: "description", Query: "ralink"}, false},
+		{models.Search{Field: "ALL", Query: "realtek"}, false},
+	} {
+		if hashMatches(&c.search, hash) != c.want {
+			t.Error("expected ", c.want, " for ", c.search)
+		}
+	}
+
+	dev := &models.Device{}
+	fillDevice(dev, hash)
+	if !dev.Exists || dev.Logicalname != "wlan1" || dev.Vendor != "ralink technology, corp." {
+		t.Error("unexpected device ", dev)
+	}
+}
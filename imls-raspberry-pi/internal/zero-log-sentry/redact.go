@@ -0,0 +1,63 @@
+package zls
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// MACPattern matches MAC addresses written with colons or dashes
+// (de:ad:be:ef:00:00), or dotted, the way Cisco writes them
+// (dead.beef.0000).
+var MACPattern = regexp.MustCompile(
+	`\b(?:[0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}\b|\b(?:[0-9A-Fa-f]{4}\.){2}[0-9A-Fa-f]{4}\b`)
+
+// redactKey only lives as long as the process. Within a run, the same
+// device always redacts the same way, so a log can still be followed;
+// across runs, nothing lines up.
+var redactKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// RedactMAC stands in for a MAC address in the logs.
+func RedactMAC(mac string) string {
+	normal := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(mac))
+	h := hmac.New(sha256.New, redactKey)
+	h.Write([]byte(normal))
+	return fmt.Sprintf("mac:%x", h.Sum(nil)[:6])
+}
+
+// Redact replaces anything that looks like a MAC address in `data`.
+func Redact(data []byte) []byte {
+	return MACPattern.ReplaceAllFunc(data, func(mac []byte) []byte {
+		return []byte(RedactMAC(string(mac)))
+	})
+}
+
+// A Redactor passes log lines on with any MAC addresses redacted, in the
+// message or in any field. It goes in front of every writer the logs
+// leave the device by.
+type Redactor struct {
+	w io.Writer
+}
+
+func NewRedactor(w io.Writer) *Redactor {
+	return &Redactor{w: w}
+}
+
+func (r *Redactor) Write(data []byte) (int, error) {
+	if _, err := r.w.Write(Redact(data)); err != nil {
+		return 0, err
+	}
+	// What was written is longer than what we were given; as far as the
+	// logger knows, it all went.
+	return len(data), nil
+}
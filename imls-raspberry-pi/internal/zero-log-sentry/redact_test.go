@@ -0,0 +1,98 @@
+package zls
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+)
+
+func TestRedact(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+	}{
+		{"DE:AD:BE:EF:00:00", RedactMAC("de:ad:be:ef:00:00")},
+		{"saw de-ad-be-ef-00-00 twice", "saw " + RedactMAC("DE:AD:BE:EF:00:00") + " twice"},
+		{"dead.beef.0000", RedactMAC("de:ad:be:ef:00:00")},
+		// Not MACs.
+		{"10:04:59", "10:04:59"},
+		{"fe80::1ff:fe23:4567:890a", "fe80::1ff:fe23:4567:890a"},
+		{"mac:0123456789abcdef", "mac:0123456789abcdef"},
+		{"DE:AD:BE:EF:00", "DE:AD:BE:EF:00"},
+	} {
+		if got := string(Redact([]byte(c.in))); got != c.out {
+			t.Error("redacting ", c.in, ": expected ", c.out, ", got ", got)
+		}
+	}
+	if RedactMAC("DE:AD:BE:EF:00:00") == RedactMAC("DE:AD:BE:EF:00:01") {
+		t.Error("different devices should redact differently")
+	}
+}
+
+func TestRedactorLogs(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(NewRedactor(&out))
+	logger.Info().
+		Str("mac", "DE:AD:BE:EF:00:00").
+		Strs("macs", []string{"be:ef:00:00:00:00"}).
+		Err(errors.New("no such device C0:FF:EE:00:00:00")).
+		Msg("ignoring de:ad:be:ef:00:01")
+
+	line := out.String()
+	for _, mac := range []string{"DE:AD:BE:EF:00:00", "be:ef:00:00:00:00", "C0:FF:EE:00:00:00", "de:ad:be:ef:00:01"} {
+		if strings.Contains(strings.ToLower(line), strings.ToLower(mac)) {
+			t.Error("found ", mac, " in ", line)
+		}
+		if !strings.Contains(line, RedactMAC(mac)) {
+			t.Error("expected ", mac, " to be redacted in ", line)
+		}
+	}
+	if !strings.HasSuffix(line, "\n") || !strings.Contains(line, `"level":"info"`) {
+		t.Error("the rest of the line should be untouched: ", line)
+	}
+}
+
+// events keeps what would have gone to Sentry.
+type events struct {
+	sent []*sentry.Event
+}
+
+func (e *events) Flush(time.Duration) bool       { return true }
+func (e *events) Configure(sentry.ClientOptions) {}
+func (e *events) SendEvent(event *sentry.Event)  { e.sent = append(e.sent, event) }
+
+func TestSentryRedacted(t *testing.T) {
+	transport := &events{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	logger := zerolog.New(newSentryWriter("test", client, &out))
+	logger.Error().
+		Str("adapter", "wlan1").
+		Str("bssid", "DE:AD:BE:EF:00:00").
+		Err(errors.New("lost DE:AD:BE:EF:00:01")).
+		Msg("capture failed near dead.beef.0002")
+
+	if strings.Contains(out.String(), "DE:AD:BE:EF") || strings.Contains(out.String(), "dead.beef") {
+		t.Error("a MAC address reached stdout: ", out.String())
+	}
+	if len(transport.sent) != 1 {
+		t.Fatal("expected one event, got ", len(transport.sent))
+	}
+	event := transport.sent[0]
+	if event.Message != "capture failed near "+RedactMAC("dead.beef.0002") {
+		t.Error("unexpected message ", event.Message)
+	}
+	if event.Extra["bssid"] != RedactMAC("DE:AD:BE:EF:00:00") || event.Extra["adapter"] != "wlan1" {
+		t.Error("unexpected extras ", event.Extra)
+	}
+	if len(event.Exception) != 1 || event.Exception[0].Type != "lost "+RedactMAC("DE:AD:BE:EF:00:01") {
+		t.Error("unexpected exception ", event.Exception)
+	}
+}
@@ -93,11 +93,16 @@ func SetupZeroLogSentry(name string, dsn string) {
 	if err != nil {
 		log.Error().Err(err).Msg("could not initialize sentry")
 	} else {
-		customWriter := ZeroLogSentry{
-			client: client,
-			name:   name,
-		}
-		writer := io.MultiWriter(&customWriter, os.Stdout)
-		log.Logger = zerolog.New(writer).With().Timestamp().Logger()
+		log.Logger = zerolog.New(newSentryWriter(name, client, os.Stdout)).With().Timestamp().Logger()
+	}
+}
+
+// newSentryWriter sends log lines to Sentry and `out`, MAC addresses
+// redacted from both.
+func newSentryWriter(name string, client *sentry.Client, out io.Writer) io.Writer {
+	customWriter := ZeroLogSentry{
+		client: client,
+		name:   name,
 	}
+	return NewRedactor(io.MultiWriter(&customWriter, out))
 }